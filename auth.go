@@ -0,0 +1,158 @@
+package tds
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Authenticator negotiates a security session at login,
+// for the network authentication mechanisms such as Kerberos.
+// The tokens are opaque to the driver, which relays them to the server.
+type Authenticator interface {
+	// Mechanism returns the object identifier of the security mechanism
+	Mechanism() asn1.ObjectIdentifier
+	// InitialToken returns the first token sent to the server
+	InitialToken() ([]byte, error)
+	// NextToken processes a token sent by the server and returns the next one to send,
+	// or nil when the negotiation is complete.
+	NextToken(serverToken []byte) ([]byte, error)
+}
+
+// AuthenticatorFactory returns the authenticator of a new connection.
+// It is given the parameters of the DSN, such as spn, the service principal name of the server.
+type AuthenticatorFactory func(params url.Values) (Authenticator, error)
+
+// KerberosMechanism is the object identifier of the Kerberos v5 GSSAPI mechanism
+var KerberosMechanism = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+
+// authenticators registered, by name
+var authenticators = struct {
+	sync.Mutex
+	m map[string]AuthenticatorFactory
+}{m: map[string]AuthenticatorFactory{}}
+
+// RegisterAuthenticator makes an authentication mechanism available
+// to the connections with the auth DSN parameter set to its name.
+// Kerberos is registered as "kerberos" when built with the gssapi tag,
+// and can be replaced by another implementation.
+func RegisterAuthenticator(name string, f AuthenticatorFactory) {
+	authenticators.Lock()
+	defer authenticators.Unlock()
+	authenticators.m[name] = f
+}
+
+// registeredAuthenticator returns true if an authenticator is registered with the given name
+func registeredAuthenticator(name string) bool {
+	authenticators.Lock()
+	defer authenticators.Unlock()
+	_, ok := authenticators.m[name]
+	return ok
+}
+
+// errNoAuthenticator reports an authentication mechanism not registered
+func errNoAuthenticator(name string) error {
+	if name == "kerberos" {
+		return errors.New("tds: kerberos authentication requires building with the gssapi tag")
+	}
+	return fmt.Errorf("tds: no authenticator registered for '%s'", name)
+}
+
+// newAuthenticator returns a new authenticator of the given name
+func newAuthenticator(name string, params url.Values) (Authenticator, error) {
+	authenticators.Lock()
+	f, ok := authenticators.m[name]
+	authenticators.Unlock()
+	if !ok {
+		return nil, errNoAuthenticator(name)
+	}
+	auth, err := f(params)
+	if err != nil {
+		return nil, fmt.Errorf("tds: %s authentication failed: %s", name, err)
+	}
+	return auth, nil
+}
+
+// security session negotiation
+const (
+	msgHasArgs   = 0x01
+	msgSecOpaque = 0x0B // TDS_MSG_SEC_OPAQUE, carries the security tokens
+	secVersion   = 50
+	secSession   = 1 // the token establishes a security session
+	// maximum number of tokens exchanged
+	maxNegotiateRounds = 10
+)
+
+// ErrNegotiationFailed is raised when the server does not accept the security tokens
+var ErrNegotiationFailed = errors.New("tds: login failed. Security negotiation rejected")
+
+// negotiate relays the tokens of the authenticator and of the server
+// until the server acknowledges the login.
+func (s *session) negotiate(ctx context.Context, prm connParams, ack *loginAck) error {
+	auth, err := newAuthenticator(prm.auth, prm.authParams)
+	if err != nil {
+		return err
+	}
+	mech, err := asn1.Marshal(auth.Mechanism())
+	if err != nil {
+		return fmt.Errorf("tds: invalid security mechanism: %s", err)
+	}
+	token, err := auth.InitialToken()
+	if err != nil {
+		return fmt.Errorf("tds: security token failed: %s", err)
+	}
+
+	for round := 0; ; round++ {
+		params, err := s.readLoginResponse(ctx, ack)
+		if err != nil {
+			return err
+		}
+
+		// the server's last token, if any, completes a mutual authentication
+		var serverToken []byte
+		for _, p := range params {
+			if b, ok := p.([]byte); ok {
+				serverToken = b
+			}
+		}
+		if ack.ack == loginSuccessToken {
+			if serverToken != nil {
+				if _, err = auth.NextToken(serverToken); err != nil {
+					return fmt.Errorf("tds: security token failed: %s", err)
+				}
+			}
+			return nil
+		}
+		if ack.ack != loginNegotiateToken || round >= maxNegotiateRounds {
+			return ErrNegotiationFailed
+		}
+
+		// the first token answers the login
+		if round > 0 {
+			if token, err = auth.NextToken(serverToken); err != nil {
+				return fmt.Errorf("tds: security token failed: %s", err)
+			}
+		}
+		if token == nil {
+			return ErrNegotiationFailed
+		}
+
+		msg := &sybMsg{msg: newMsg(msgToken), field1: msgHasArgs, field2: msgSecOpaque}
+		cols := &columns{msg: newMsg(paramFmtToken), fmts: []colFmt{
+			colFmt{colType: getType(intType, 0)},
+			colFmt{colType: getType(intType, 0)},
+			colFmt{colType: getType(longBinaryType, 2147483647)},
+			colFmt{colType: getType(longBinaryType, 2147483647)},
+		}}
+		row := &row{msg: newMsg(paramToken),
+			data:    []driver.Value{int64(secVersion), int64(secSession), mech, token},
+			columns: cols.fmts[:]}
+		if err = s.b.send(ctx, normalPacket, msg, cols, row); err != nil {
+			return fmt.Errorf("tds: login send failed: %s", err)
+		}
+	}
+}
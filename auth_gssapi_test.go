@@ -0,0 +1,34 @@
+//go:build gssapi && cgo && !darwin && !windows
+// +build gssapi,cgo,!darwin,!windows
+
+package tds
+
+import (
+	"net/url"
+	"os"
+	"testing"
+)
+
+// without credentials, the library reports why the first token cannot be made
+func TestGSSAPIAuthenticator(t *testing.T) {
+	if _, err := newAuthenticator("kerberos", url.Values{}); err == nil {
+		t.Error("expected an error without spn")
+	}
+
+	ccache := os.Getenv("KRB5CCNAME")
+	os.Setenv("KRB5CCNAME", "FILE:/nonexistent/krb5cc")
+	defer os.Setenv("KRB5CCNAME", ccache)
+
+	auth, err := newAuthenticator("kerberos", url.Values{"spn": {"ASE/db.local@EXAMPLE.COM"}})
+	if err != nil {
+		t.Fatal("newAuthenticator failed:", err)
+	}
+	if auth.Mechanism().String() != KerberosMechanism.String() {
+		t.Error("unexpected mechanism:", auth.Mechanism())
+	}
+	if token, err := auth.InitialToken(); err == nil {
+		t.Errorf("expected an error without credentials, got a token of %d bytes", len(token))
+	} else {
+		t.Log(err)
+	}
+}
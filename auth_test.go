@@ -0,0 +1,192 @@
+package tds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// scriptedAuth sends the scripted tokens, and keeps the tokens of the server
+type scriptedAuth struct {
+	tokens   [][]byte
+	received [][]byte
+	err      error // returned by NextToken
+}
+
+func (a *scriptedAuth) Mechanism() asn1.ObjectIdentifier { return KerberosMechanism }
+func (a *scriptedAuth) InitialToken() ([]byte, error)    { return a.next(), nil }
+
+func (a *scriptedAuth) NextToken(token []byte) ([]byte, error) {
+	a.received = append(a.received, token)
+	if a.err != nil {
+		return nil, a.err
+	}
+	return a.next(), nil
+}
+
+func (a *scriptedAuth) next() []byte {
+	if len(a.tokens) == 0 {
+		return nil
+	}
+	token := a.tokens[0]
+	a.tokens = a.tokens[1:]
+	return token
+}
+
+// pipeDialer connects to a fake server
+type pipeDialer struct{ c net.Conn }
+
+func (d pipeDialer) Dial(network, addr string) (net.Conn, error) { return d.c, nil }
+
+// serverReply is the login status sent by the fake server, along with a token if not nil
+type serverReply struct {
+	ack   int8
+	token []byte
+}
+
+// fakeLoginServer answers each request with the next reply,
+// and returns the requests read once done
+func fakeLoginServer(c net.Conn, replies []serverReply) <-chan [][]byte {
+	requests := make(chan [][]byte, 1)
+	go func() {
+		var reqs [][]byte
+		defer func() { requests <- reqs }()
+		defer c.Close()
+		b := newBuf(512, c)
+		for _, r := range replies {
+			var req []byte
+			for {
+				if err := b.readPkt(true); err != nil {
+					return
+				}
+				req = append(req, b.pb.Bytes()...)
+				if b.h.status&eom != 0 {
+					break
+				}
+			}
+			reqs = append(reqs, req)
+
+			msgs := []messageReaderWriter{&loginAck{msg: newMsg(loginAckToken), ack: r.ack, server: "fake"}}
+			if r.token != nil {
+				fmts := []colFmt{colFmt{colType: getType(longBinaryType, 2147483647)}}
+				msgs = append(msgs, &columns{msg: newMsg(paramFmtToken), fmts: fmts},
+					&row{msg: newMsg(paramToken), data: []driver.Value{r.token}, columns: fmts})
+			}
+			msgs = append(msgs, &done{msg: newMsg(doneToken)})
+			if err := b.send(nil, replyPacket, msgs...); err != nil {
+				return
+			}
+		}
+	}()
+	return requests
+}
+
+// the tokens are relayed until the server acknowledges the login
+func TestNegotiate(t *testing.T) {
+	var auth *scriptedAuth
+	RegisterAuthenticator("scripted", func(params url.Values) (Authenticator, error) {
+		return auth, nil
+	})
+	mech, _ := asn1.Marshal(KerberosMechanism)
+
+	// the round following the last one allowed fails
+	tooMany := make([]serverReply, maxNegotiateRounds+1)
+	for i := range tooMany {
+		tooMany[i] = serverReply{loginNegotiateToken, []byte("again")}
+	}
+	var tooManyReceived []string
+	for i := 1; i < maxNegotiateRounds; i++ {
+		tooManyReceived = append(tooManyReceived, "again")
+	}
+
+	for _, test := range []struct {
+		name     string
+		auth     *scriptedAuth
+		replies  []serverReply
+		err      string
+		received []string
+		sent     []string // tokens expected in the requests following the login
+	}{
+		{name: "mutual authentication",
+			auth: &scriptedAuth{tokens: [][]byte{[]byte("t0"), []byte("t1")}},
+			replies: []serverReply{{loginNegotiateToken, nil}, {loginNegotiateToken, []byte("s1")},
+				{loginSuccessToken, []byte("s2")}},
+			received: []string{"s1", "s2"},
+			sent:     []string{"t0", "t1"}},
+		{name: "single token",
+			auth:    &scriptedAuth{tokens: [][]byte{[]byte("t0")}},
+			replies: []serverReply{{loginNegotiateToken, nil}, {loginSuccessToken, nil}},
+			sent:    []string{"t0"}},
+		{name: "rejected",
+			auth:    &scriptedAuth{tokens: [][]byte{[]byte("t0")}},
+			replies: []serverReply{{loginNegotiateToken, nil}, {loginFailedToken, nil}},
+			err:     ErrNegotiationFailed.Error(),
+			sent:    []string{"t0"}},
+		{name: "token failure",
+			auth:     &scriptedAuth{tokens: [][]byte{[]byte("t0")}, err: errors.New("ticket expired")},
+			replies:  []serverReply{{loginNegotiateToken, nil}, {loginNegotiateToken, []byte("s1")}},
+			err:      "ticket expired",
+			received: []string{"s1"},
+			sent:     []string{"t0"}},
+		{name: "no token left",
+			auth:     &scriptedAuth{tokens: [][]byte{[]byte("t0")}},
+			replies:  []serverReply{{loginNegotiateToken, nil}, {loginNegotiateToken, []byte("s1")}},
+			err:      ErrNegotiationFailed.Error(),
+			received: []string{"s1"},
+			sent:     []string{"t0"}},
+		{name: "too many rounds",
+			auth:     &scriptedAuth{tokens: bytes.Split(bytes.Repeat([]byte("t,"), 20), []byte(","))},
+			replies:  tooMany,
+			err:      ErrNegotiationFailed.Error(),
+			received: tooManyReceived},
+	} {
+		auth = test.auth
+		prm, err := parseDSN("tds://fake:5000/?auth=scripted&loginTimeout=5")
+		if err != nil {
+			t.Fatal("parseDSN failed:", err)
+		}
+		client, server := net.Pipe()
+		prm.dialer = pipeDialer{client}
+		requests := fakeLoginServer(server, test.replies)
+
+		_, err = newSession(prm)
+		client.Close()
+		reqs := <-requests
+
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: login failed: %s", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+		}
+
+		var received []string
+		for _, token := range test.auth.received {
+			received = append(received, string(token))
+		}
+		if strings.Join(received, ",") != strings.Join(test.received, ",") {
+			t.Errorf("%s: expected the server tokens %v, got %v", test.name, test.received, received)
+		}
+
+		// the login, then a security message per token
+		if test.sent == nil {
+			continue
+		}
+		if len(reqs) != len(test.sent)+1 {
+			t.Errorf("%s: expected %d requests, got %d", test.name, len(test.sent)+1, len(reqs))
+			continue
+		}
+		for i, token := range test.sent {
+			req := reqs[i+1]
+			if req[0] != byte(msgToken) || !bytes.Contains(req, mech) || !bytes.Contains(req, []byte(token)) {
+				t.Errorf("%s: request %d does not hold the mechanism and the token %s: %v",
+					test.name, i+1, token, req)
+			}
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package tds
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	bin "github.com/thda/tds/binary"
+)
+
+// ErrBulkUnsupported is returned when the table cannot be loaded with the bulk protocol.
+// Only the allpages locked tables without identity, text, image or unicode columns are supported.
+var ErrBulkUnsupported = errors.New("tds: bulk copy unsupported for this table")
+
+// ErrValueTooLong is returned in a BulkRowError when a value does not fit in its column.
+// The row is rejected, as bcp does, rather than truncated.
+var ErrValueTooLong = errors.New("value too long for the column")
+
+// bulkIdentRe matches the plain identifiers, which make the table names
+var bulkIdentRe = regexp.MustCompile(`^[\pL_#@][\pL\pN_#@$]*$`)
+
+// validBulkTable returns true for the table names in the form [db.[owner].]table
+func validBulkTable(table string) bool {
+	parts := strings.Split(table, ".")
+	if len(parts) > 3 {
+		return false
+	}
+	for i, p := range parts {
+		// the owner can be omitted, as in db..table
+		if p == "" && len(parts) == 3 && i == 1 {
+			continue
+		}
+		if !bulkIdentRe.MatchString(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// BulkRowError is returned by AddRow when a row cannot be encoded.
+// The row is not sent, and the bulk copy can go on.
+type BulkRowError struct {
+	Column string // empty if the row has the wrong number of values
+	Err    error
+}
+
+func (e BulkRowError) Error() string {
+	if e.Column == "" {
+		return "tds: " + e.Err.Error()
+	}
+	return "tds: column " + e.Column + ": " + e.Err.Error()
+}
+
+// BulkOptions tunes a bulk copy
+type BulkOptions struct {
+	// BatchSize is the number of rows sent before the server commits them.
+	// With 0, the rows are committed by Flush and Done only.
+	BatchSize int
+}
+
+// bulkColumn is a column of the table loaded, as stored by the server
+type bulkColumn struct {
+	f         colFmt // type of the values
+	offset    int    // offset in the row of the fixed length columns, negative for the others
+	length    int
+	nullable  bool
+	bit       byte // mask of the bit columns, which share bytes
+	converter driver.ValueConverter
+}
+
+// Bulk copies rows into a table with the bulk insert protocol,
+// which is much faster than inserts for large volumes.
+// The connection must not be used for anything else until Done is called.
+type Bulk struct {
+	s     *session
+	ctx   context.Context
+	table string
+	opts  BulkOptions
+
+	cols     []bulkColumn
+	fixedLen int   // length of the fixed part of the rows
+	varCols  []int // variable length columns, in the row order
+
+	// scratch buffer and encoder of the values
+	vb bytes.Buffer
+	e  bin.Encoder
+
+	inBatch   bool   // an insert bulk is in progress
+	stopWatch func() // stops the cancel watcher of the batch
+	pending   int    // rows sent in the current batch
+	copied    int64  // rows committed
+}
+
+// bulkColumnsQuery reads the storage of the columns, and the locking scheme of the table
+const bulkColumnsQuery = `select c.name, convert(int, c.type), c.length, c.offset, convert(int, c.status),
+	convert(int, isnull(c.prec, 0)), convert(int, isnull(c.scale, 0)), convert(int, o.sysstat2) & 49152
+from %[1]ssysobjects o, %[1]ssyscolumns c
+where o.id = object_id('%[2]s') and c.id = o.id
+order by c.colid`
+
+// syscolumns status bits
+const (
+	colBitMask  = 0x07
+	colNullable = 0x08
+	colIdentity = 0x80
+)
+
+// NewBulk prepares the bulk copy of rows into a table.
+// The values of each row are given in the order of the table's columns.
+func (c *Conn) NewBulk(ctx context.Context, table string, opts BulkOptions) (*Bulk, error) {
+	if !c.valid {
+		return nil, driver.ErrBadConn
+	}
+	// the name is sent as is in the insert bulk command
+	if !validBulkTable(table) {
+		return nil, fmt.Errorf("tds: bulk copy failed: invalid table name '%s'", table)
+	}
+	bk := newBulk(ctx, c.session, table, opts)
+	if c.charConvert {
+		e, err := getEncoding(c.charset)
+		if err != nil {
+			return nil, err
+		}
+		bk.e.SetCharset(e)
+	}
+
+	// the catalog of another database is prefixed by its name
+	db := ""
+	if parts := strings.Split(table, "."); len(parts) == 3 && parts[0] != "" {
+		db = parts[0] + ".."
+	}
+	rows, err := c.simpleQuery(ctx, fmt.Sprintf(bulkColumnsQuery, db, strings.Replace(table, "'", "''", -1)))
+	if err != nil {
+		return nil, c.checkErr(err, "tds: bulk copy failed", false)
+	}
+	defer rows.Close()
+
+	vals := make([]driver.Value, 8)
+	for {
+		if err = rows.Next(vals); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, c.checkErr(err, "tds: bulk copy failed", false)
+		}
+		var ints [7]int
+		for i := range ints {
+			if n, ok := vals[i+1].(int64); ok {
+				ints[i] = int(n)
+			}
+		}
+		typ, length, offset, status, prec, scale, locking := ints[0], ints[1], ints[2], ints[3], ints[4], ints[5], ints[6]
+		name, _ := vals[0].(string)
+		if locking != 0 || status&colIdentity != 0 {
+			return nil, ErrBulkUnsupported
+		}
+		if err = bk.addColumn(name, dataType(typ), length, offset, status, prec, scale); err != nil {
+			return nil, err
+		}
+	}
+	if len(bk.cols) == 0 {
+		return nil, fmt.Errorf("tds: bulk copy failed: table %s not found", table)
+	}
+	return bk, nil
+}
+
+// newBulk returns a bulk copy without columns
+func newBulk(ctx context.Context, s *session, table string, opts BulkOptions) *Bulk {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	bk := &Bulk{s: s, ctx: ctx, table: table, opts: opts}
+	bk.e = bin.NewEncoder(&bk.vb, binary.LittleEndian)
+	return bk
+}
+
+// bulkType returns the type of the values of a column, given its storage type and length
+func bulkType(t dataType, length int) (dataType, bool) {
+	switch t {
+	case intNType:
+		switch length {
+		case 1:
+			return tinyintType, true
+		case 2:
+			return smallintType, true
+		case 4:
+			return intType, true
+		case 8:
+			return bigintType, true
+		}
+	case uIntNType:
+		switch length {
+		case 2:
+			return uSmallintType, true
+		case 4:
+			return uIntType, true
+		case 8:
+			return uBigintType, true
+		}
+	case floatNType:
+		switch length {
+		case 4:
+			return realType, true
+		case 8:
+			return floatType, true
+		}
+	case moneyNType:
+		switch length {
+		case 4:
+			return smallmoneyType, true
+		case 8:
+			return moneyType, true
+		}
+	case datetimeNType:
+		switch length {
+		case 4:
+			return smalldatetimeType, true
+		case 8:
+			return datetimeType, true
+		}
+	case dateNType:
+		return dateType, true
+	case timeNType:
+		return timeType, true
+	case bigdatetimeNType:
+		return bigdatetimeType, true
+	case bigtimeNType:
+		return bigtimeType, true
+	case numericNType:
+		return numericType, true
+	case decimalNType:
+		return decimalType, true
+	case tinyintType, smallintType, intType, bigintType,
+		uTinyintType, uSmallintType, uIntType, uBigintType,
+		realType, floatType, moneyType, smallmoneyType,
+		datetimeType, smalldatetimeType, dateType, timeType, bigdatetimeType, bigtimeType,
+		bitType, charType, varcharType, binaryType, varbinaryType, numericType, decimalType:
+		return t, true
+	}
+	return 0, false
+}
+
+// addColumn adds a column, as described by syscolumns
+func (bk *Bulk) addColumn(name string, typ dataType, length, offset, status, prec, scale int) error {
+	t, ok := bulkType(typ, length)
+	if !ok {
+		return ErrBulkUnsupported
+	}
+	c := bulkColumn{f: colFmt{name: name, colType: getType(t, length)}, offset: offset,
+		length: length, nullable: status&colNullable != 0}
+	c.f.precision, c.f.scale = int8(prec), int8(scale)
+	if t == bitType {
+		c.bit = 1 << uint(status&colBitMask)
+	}
+	c.converter = c.f.parameterConverter()
+
+	bk.cols = append(bk.cols, c)
+	if offset < 0 {
+		bk.varCols = append(bk.varCols, len(bk.cols)-1)
+		sort.Slice(bk.varCols, func(i, j int) bool {
+			return bk.cols[bk.varCols[i]].offset > bk.cols[bk.varCols[j]].offset
+		})
+	} else if offset+length > bk.fixedLen {
+		bk.fixedLen = offset + length
+	}
+	return nil
+}
+
+// Columns returns the names of the table's columns, in the order of the row values
+func (bk *Bulk) Columns() []string {
+	names := make([]string, len(bk.cols))
+	for i, c := range bk.cols {
+		names[i] = c.f.name
+	}
+	return names
+}
+
+// value returns the bytes of a value, as stored in the row
+func (bk *Bulk) value(c *bulkColumn, v driver.Value) ([]byte, error) {
+	bk.vb.Reset()
+	switch c.f.dataType {
+	case charType, varcharType:
+		s, ok := v.(string)
+		if !ok {
+			return nil, ErrBadType
+		}
+		bk.e.WriteString(s)
+	case binaryType, varbinaryType:
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, ErrBadType
+		}
+		bk.vb.Write(b)
+	case numericType, decimalType:
+		// skip the length of the wire format, and left pad the magnitude
+		if err := encodeNumeric(&bk.e, v, c.f.colType); err != nil {
+			return nil, err
+		}
+		size := numericBytes[int(c.f.precision)]
+		b := bk.vb.Bytes()[1:]
+		if len(b) < size {
+			b = append(append([]byte{b[0]}, make([]byte, size-len(b))...), b[1:]...)
+		}
+		return b, nil
+	default:
+		if err := c.f.encodingProps.writer(&bk.e, v, c.f.colType); err != nil {
+			return nil, err
+		}
+	}
+	if err := bk.e.Err(); err != nil {
+		return nil, err
+	}
+	b := bk.vb.Bytes()
+	if len(b) > c.length {
+		return nil, ErrValueTooLong
+	}
+	return b, nil
+}
+
+// record builds the row as stored by the server:
+// a header, the fixed length columns at their offset,
+// and the variable length columns followed by their offsets.
+func (bk *Bulk) record(values []driver.Value) ([]byte, error) {
+	if len(values) != len(bk.cols) {
+		return nil, BulkRowError{Err: fmt.Errorf("%d values given, expected %d", len(values), len(bk.cols))}
+	}
+
+	// the header holds the number of variable columns and the row number, set by the server
+	fixedLen := bk.fixedLen
+	if fixedLen < 2 {
+		fixedLen = 2
+	}
+	rec := make([]byte, fixedLen, fixedLen+64)
+	data := make([][]byte, len(bk.cols))
+	for i := range bk.cols {
+		c := &bk.cols[i]
+		v, err := c.converter.ConvertValue(values[i])
+		if err != nil {
+			return nil, BulkRowError{Column: c.f.name, Err: err}
+		}
+		if v == nil {
+			if !c.nullable {
+				return nil, BulkRowError{Column: c.f.name, Err: ErrNonNullable}
+			}
+			continue
+		}
+
+		if c.f.dataType == bitType {
+			if v.(bool) {
+				rec[c.offset] |= c.bit
+			}
+			continue
+		}
+		b, err := bk.value(c, v)
+		if err != nil {
+			return nil, BulkRowError{Column: c.f.name, Err: err}
+		}
+
+		if c.offset >= 0 {
+			n := copy(rec[c.offset:c.offset+c.length], b)
+			if c.f.dataType == charType {
+				for ; n < c.length; n++ {
+					rec[c.offset+n] = ' '
+				}
+			}
+			continue
+		}
+		// empty strings are stored as a space
+		if len(b) == 0 && c.f.dataType == varcharType {
+			b = []byte{' '}
+		}
+		data[i] = append([]byte(nil), b...)
+	}
+
+	if len(bk.varCols) == 0 {
+		return rec, nil
+	}
+
+	// the row length is followed by the variable columns
+	offsets := []int{len(rec) + 2}
+	rec = append(rec, 0, 0)
+	for _, i := range bk.varCols {
+		rec = append(rec, data[i]...)
+		offsets = append(offsets, len(rec))
+	}
+
+	// the trailing null columns are not sent
+	n := len(bk.varCols)
+	for n > 0 && offsets[n] == offsets[n-1] {
+		n--
+	}
+	if n == 0 {
+		return rec[:fixedLen], nil
+	}
+	rec = rec[:offsets[n]]
+	rec = append(rec, offsetTable(offsets[:n+1])...)
+
+	binary.LittleEndian.PutUint16(rec[fixedLen:], uint16(len(rec)))
+	rec[0] = byte(n)
+	return rec, nil
+}
+
+// offsetTable returns the offsets of the variable columns, last one first,
+// preceded by their count and the adjustment table giving their high bytes.
+func offsetTable(offsets []int) (table []byte) {
+	n := len(offsets) - 1
+	if offsets[n]/256 == offsets[n-1]/256 {
+		table = append(table, byte(n+1))
+	}
+	for top := offsets[n] >> 8; top > 0; top-- {
+		count := 1
+		for _, o := range offsets {
+			if o>>8 < top {
+				count++
+			}
+		}
+		table = append(table, byte(count))
+	}
+	for i := n; i >= 0; i-- {
+		table = append(table, byte(offsets[i]))
+	}
+	return table
+}
+
+// AddRow sends a row. The values are converted as the statements' parameters.
+// The rows are committed every BatchSize rows.
+// Cancelling the context discards the rows not committed yet.
+func (bk *Bulk) AddRow(values []driver.Value) error {
+	// end the batch, its rows are rolled back
+	if err := bk.ctx.Err(); err != nil {
+		if bk.inBatch {
+			_, err = bk.Flush()
+		}
+		return err
+	}
+	rec, err := bk.record(values)
+	if err != nil {
+		return err
+	}
+	if len(rec) > 0xFFFF {
+		return BulkRowError{Err: fmt.Errorf("row too long (%d bytes)", len(rec))}
+	}
+
+	if !bk.inBatch {
+		if err = bk.start(); err != nil {
+			return err
+		}
+	}
+	bk.s.b.pe.WriteUint16(uint16(len(rec)))
+	bk.s.b.pe.Write(rec)
+	if err = bk.s.b.pe.Err(); err != nil {
+		bk.end()
+		return bk.s.checkErr(err, "tds: bulk copy failed", false)
+	}
+
+	if bk.pending++; bk.opts.BatchSize > 0 && bk.pending >= bk.opts.BatchSize {
+		_, err = bk.Flush()
+	}
+	return err
+}
+
+// start sends the insert bulk command and switches to bulk packets
+func (bk *Bulk) start() error {
+	if _, err := bk.s.simpleExec(bk.ctx, "insert bulk "+bk.table); err != nil {
+		return err
+	}
+	bk.s.b.initPkt(bulkPacket)
+	bk.stopWatch = bk.s.b.watchCancel(bk.ctx, false)
+	bk.inBatch, bk.pending = true, 0
+	return nil
+}
+
+// end stops the cancel watcher of the batch
+func (bk *Bulk) end() {
+	if bk.stopWatch != nil {
+		bk.stopWatch()
+		bk.stopWatch = nil
+	}
+	bk.inBatch = false
+}
+
+// Flush commits the rows sent, and returns the number of rows copied
+// as counted by the server. Once the context is done, the rows are discarded
+// and the context's error is returned.
+func (bk *Bulk) Flush() (copied int64, err error) {
+	if !bk.inBatch {
+		return 0, nil
+	}
+	defer bk.end()
+
+	// the server is sent an attention, discarding the batch
+	cancelErr := bk.ctx.Err()
+	if cancelErr != nil {
+		bk.s.b.cancel(cancelErr, false)
+	}
+	if err = bk.s.b.sendPkt(eom); err != nil {
+		err = bk.s.checkErr(err, "tds: bulk copy failed", false)
+	} else {
+		bk.s.clearResult()
+		for f := bk.s.initState(bk.ctx, map[token]messageReader{}); f != nil; f = f(bk.s.state) {
+		}
+		err = bk.s.checkErr(bk.s.state.err, "tds: bulk copy failed", true)
+	}
+	bk.pending = 0
+	if cancelErr != nil {
+		return 0, cancelErr
+	} else if err != nil {
+		return 0, err
+	}
+
+	if bk.s.res.hasAffectedRows {
+		copied = bk.s.res.affectedRows
+	}
+	bk.copied += copied
+	return copied, nil
+}
+
+// Done commits the remaining rows and returns the number of rows copied
+func (bk *Bulk) Done() (int64, error) {
+	_, err := bk.Flush()
+	return bk.copied, err
+}
@@ -0,0 +1,135 @@
+package tds
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+// the rows are laid out as stored by the server
+func TestBulkRecord(t *testing.T) {
+	bk := newBulk(nil, nil, "test", BulkOptions{})
+	for _, c := range []struct {
+		name           string
+		typ            dataType
+		length, offset int
+		status         int
+	}{
+		{"id", intType, 4, 2, 0},
+		{"flag", bitType, 1, 6, 1},
+		{"code", charType, 3, 7, 0},
+		{"label", varcharType, 10, -1, colNullable},
+		{"amount", intNType, 4, -2, colNullable},
+	} {
+		if err := bk.addColumn(c.name, c.typ, c.length, c.offset, c.status, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		values   []driver.Value
+		expected []byte
+	}{
+		// header, id, flag, code padded, row length, label, amount, offsets
+		{[]driver.Value{int64(1), true, "ab", "xy", int64(2)},
+			[]byte{2, 0, 1, 0, 0, 0, 2, 'a', 'b', ' ', 22, 0, 'x', 'y', 2, 0, 0, 0, 3, 18, 14, 12}},
+		// the trailing null columns are not sent
+		{[]driver.Value{int64(1), false, "abc", "", nil},
+			[]byte{1, 0, 1, 0, 0, 0, 0, 'a', 'b', 'c', 16, 0, ' ', 2, 13, 12}},
+		{[]driver.Value{int64(1), false, "abc", nil, nil},
+			[]byte{0, 0, 1, 0, 0, 0, 0, 'a', 'b', 'c'}},
+	} {
+		rec, err := bk.record(test.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rec, test.expected) {
+			t.Errorf("expected %v for %v, got %v", test.expected, test.values, rec)
+		}
+	}
+
+	if _, err := bk.record([]driver.Value{nil, false, "abc", nil, nil}); err == nil {
+		t.Error("expected an error for a null in a not null column")
+	}
+	if _, err := bk.record([]driver.Value{int64(1)}); err == nil {
+		t.Error("expected an error for a missing value")
+	}
+	if _, err := bk.record([]driver.Value{int64(1), false, "abcd", nil, nil}); err == nil ||
+		err.(BulkRowError).Err != ErrValueTooLong || err.(BulkRowError).Column != "code" {
+		t.Error("expected a value too long error for code, got", err)
+	}
+	if _, err := bk.record([]driver.Value{int64(1), false, "abc", "12345678901", nil}); err == nil ||
+		err.(BulkRowError).Err != ErrValueTooLong {
+		t.Error("expected a value too long error for label, got", err)
+	}
+}
+
+// the table name is sent as is in the insert bulk command
+func TestBulkTableName(t *testing.T) {
+	for _, test := range []struct {
+		table string
+		valid bool
+	}{
+		{"mytable", true},
+		{"dbo.mytable", true},
+		{"mydb..mytable", true},
+		{"mydb.dbo.#temp", true},
+		{"tempdb..testBulk", true},
+		{"", false},
+		{"a.b.c.d", false},
+		{".mytable", false},
+		{"mytable with (tablock)", false},
+		{"mytable; drop table t", false},
+	} {
+		if validBulkTable(test.table) != test.valid {
+			t.Errorf("expected valid=%v for '%s'", test.valid, test.table)
+		}
+	}
+}
+
+func TestBulk(t *testing.T) {
+	db := connect(t)
+	if db == nil {
+		t.Fatal("connect failed")
+	}
+	defer db.Close()
+	db.Exec("drop table tempdb..testBulk")
+	if _, err := db.Exec(`create table tempdb..testBulk (id int, code char(3),
+		label varchar(10) null, amount numeric(10,2) null) lock allpages`); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc interface{}) error {
+		bk, err := dc.(*Conn).NewBulk(context.Background(), "tempdb..testBulk", BulkOptions{BatchSize: 3})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			if err = bk.AddRow([]driver.Value{i, "abc", "label", "12.5"}); err != nil {
+				return err
+			}
+		}
+		copied, err := bk.Done()
+		if copied != 10 {
+			t.Errorf("expected 10 rows copied, got %d", copied)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err = db.QueryRow("select count(*) from tempdb..testBulk where amount = 12.5").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Fatalf("expected 10 rows, got %d", count)
+	}
+}
@@ -81,6 +81,12 @@ func getEncoding(sybName string) (encoding.Encoding, error) {
 	return nil, fmt.Errorf("netlib: unsupported charset: %s", sybName)
 }
 
+// LookupEncoding returns the encoding of a sybase character set,
+// or of one registered with RegisterEncoding.
+func LookupEncoding(sybaseCharsetName string) (encoding.Encoding, error) {
+	return getEncoding(sybaseCharsetName)
+}
+
 // RegisterEncoding register encoding for the charset
 func RegisterEncoding(sybaseCharsetName string, e encoding.Encoding) {
 	nameToCharsetMutex.Lock()
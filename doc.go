@@ -57,6 +57,9 @@ The most common ones are:
  - password - The login's password. Mandatory.
  - host - The host to connect to. Mandatory.
  - port - The port to bind to. Mandatory.
+ - host:port list - Several comma separated addresses can be given,
+   for instance host1:5000,host2:5000. They are tried in order until
+   a connection succeeds.
  - database - The database to use. You will connect to the login's
    default database if not specified.
  - charset - The client's character set. Default to utf8.
@@ -70,10 +73,21 @@ Less frequently used ones:
 
  - ssl - Whether or not to use SSL. The default is not to use ssl.
    Set to "on" if the server is setup to use ssl.
+ - sslCA - PEM file holding the certificate authorities to verify the
+   server's certificate with.
+ - sslSkipVerify - "true" to skip the verification of the server's certificate,
+   "false" to enforce it, the default. The certificate is verified against
+   the system's certificate authorities, or the ones of sslCA.
+ - sslServerName - Name expected in the server's certificate, when it
+   differs from the host name.
+ - sslCert, sslKey - PEM files holding the client certificate and its key.
  - encryptPassword - Can be "yes" to require password encryption,
    "no" to disable it, and "try" to try encrytping password an falling back
    to plain text password. Password encryption works on Sybase ASE 15.5
    or higher and uses RSA.
+ - auth - Name of a registered authenticator, such as "kerberos", negotiating
+   a security session instead of sending the password. See Network authentication.
+ - spn - Service principal name of the server, given to the authenticator.
  - packetSize - Network packet size. Must be less than or equal the server's
    max network packet size. The default is the server's default network
    packet size.
@@ -165,9 +179,63 @@ The following demonstrates how to handle showplan and print messages:
 		return m.Severity > 10
 	})
 
+Custom dialer
+
+One can set a custom dialer to open the network connections,
+to reach the server through a tunnel for example.
+The connector given to sql.OpenDB opens its connections with the dialer:
+
+	db := sql.OpenDB(tds.NewConnector(url, myDialer))
+
+The TLS handshake, if requested, is done over the connection returned by the dialer.
+
+Network authentication
+
+Kerberos is built in with the gssapi build tag, which requires cgo and the
+system's GSSAPI library, libgssapi_krb5. The credentials are the ones of the
+default cache, as obtained with kinit, and spn names the server's principal:
+
+	go build -tags gssapi
+
+	db, err := sql.Open("tds", "tds://host:5000/?auth=kerberos&spn=ASE/host@REALM")
+
+The user name is optional, the server maps the principal to a login.
+
+Other mechanisms, such as NTLM or a single sign-on, are added by registering
+a type implementing tds.Authenticator under the name given in the auth parameter.
+The driver relays its tokens to the server:
+
+	tds.RegisterAuthenticator("sso", func(params url.Values) (tds.Authenticator, error) {
+		return &ssoAuthenticator{spn: params.Get("spn")}, nil
+	})
+
+Bulk copy
+
+Large volumes are loaded much faster with the bulk copy protocol, as bcp does.
+The values of each row are given in the order of the table's columns,
+and converted as the statements' parameters:
+
+	conn, _ := db.Conn(ctx)
+	err := conn.Raw(func(dc interface{}) error {
+		bulk, err := dc.(*tds.Conn).NewBulk(ctx, "mydb..mytable", tds.BulkOptions{BatchSize: 10000})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = bulk.AddRow(row); err != nil {
+				return err
+			}
+		}
+		_, err = bulk.Done()
+		return err
+	})
+
+Only the allpages locked tables without identity, text, image or unicode columns are supported.
+A row with a value too long for its column is rejected with a BulkRowError, and is not sent.
+
 Limitations
 
-As of now the driver does not support bulk insert and named parameters.
+As of now the driver does not support named parameters.
 Password encryption only works for Sybase ASE > 15.5.
 
 Testing
@@ -1,12 +1,18 @@
 package tds
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -31,10 +37,15 @@ type connParams struct {
 	pid          string
 	textSize     int
 	ssl          string
+	tlsConfig    *tls.Config
 	// yes: mandatory password encryption.
 	// no: never encrypt password.
 	// try: try encryption, fallback to non encrypted password.
 	encryptPassword string
+	dialer          Dialer // custom dialer, given by the connector
+	// registered authenticator negotiating a security session instead of the password
+	auth       string
+	authParams url.Values
 }
 
 // Conn encapsulates a tds session and satisties driver.Connc
@@ -89,9 +100,20 @@ func parseDSN(dsn string) (prm connParams, err error) {
 		return prm, fmt.Errorf("tds: encryptPassword must be 'yes', 'no' or 'try'")
 	}
 
+	// network authentication
+	if prm.auth = values.Get("auth"); prm.auth != "" {
+		if !registeredAuthenticator(prm.auth) {
+			return prm, errNoAuthenticator(prm.auth)
+		}
+		prm.authParams = values
+	}
+
 	// ssl ??
 	if values.Get("ssl") == "on" {
 		prm.ssl = "on"
+		if prm.tlsConfig, err = parseTLSConfig(values); err != nil {
+			return prm, err
+		}
 	}
 
 	switch values.Get("charset") {
@@ -115,11 +137,13 @@ func parseDSN(dsn string) (prm connParams, err error) {
 	if prm.host == "" {
 		return prm, errors.New("tds: connect failed. Please specify hostname")
 	}
-	if prm.user == "" {
+	if prm.user == "" && prm.auth == "" {
 		return prm, errors.New("tds: connect failed. Please specify user")
 	}
-	if validHost.FindString(prm.host) == "" {
-		return prm, errors.New("tds: connect failed. Please specify host name in the form host:port")
+	for _, host := range strings.Split(prm.host, ",") {
+		if validHost.FindString(host) == "" {
+			return prm, errors.New("tds: connect failed. Please specify host name in the form host:port")
+		}
 	}
 
 	if prm.packetSize != 512 && prm.packetSize != 1024 &&
@@ -130,6 +154,46 @@ func parseDSN(dsn string) (prm connParams, err error) {
 	return prm, nil
 }
 
+// parseTLSConfig builds the TLS configuration from the ssl* parameters.
+// The server certificate is verified against the system's certificate authorities,
+// or the ones of sslCA, unless sslSkipVerify is "true".
+func parseTLSConfig(values url.Values) (*tls.Config, error) {
+	// the server name defaults to the name of the host dialed
+	config := &tls.Config{ServerName: values.Get("sslServerName")}
+
+	if ca := values.Get("sslCA"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("tds: could not read the CA file: %s", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tds: no certificate found in %s", ca)
+		}
+	}
+
+	switch values.Get("sslSkipVerify") {
+	case "", "false":
+	case "true":
+		config.InsecureSkipVerify = true
+	default:
+		return nil, errors.New("tds: sslSkipVerify must be 'true' or 'false'")
+	}
+
+	cert, key := values.Get("sslCert"), values.Get("sslKey")
+	if (cert == "") != (key == "") {
+		return nil, errors.New("tds: sslCert and sslKey must be given together")
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("tds: could not load the client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
 // SetErrorhandler allows setting a custom error handler.
 // The function shall accept an SQL Message and return a boolean
 // indicating if this message is indeed a critical error.
@@ -139,11 +203,17 @@ func (c *Conn) SetErrorhandler(fn func(s SybError) bool) {
 
 // NewConn returns a TDS session
 func NewConn(dsn string) (*Conn, error) {
+	return newConn(dsn, nil)
+}
+
+// newConn returns a TDS session opened with the given dialer, if any
+func newConn(dsn string, dialer Dialer) (*Conn, error) {
 	prm, err := parseDSN(dsn)
 
 	if err != nil {
 		return &emptyConn, err
 	}
+	prm.dialer = dialer
 	s, err := newSession(prm)
 	c := &Conn{session: s}
 	return c, err
@@ -154,8 +224,18 @@ func NewConn(dsn string) (*Conn, error) {
 //  - server
 //  - database
 //  - charset
+//  - host, the address connected to
+//  - packetSize, the negotiated network packet size
+//  - ssl, "on" if the connection is encrypted
 func (c Conn) GetEnv() map[string]string {
+	ssl := "off"
+	if _, ok := c.session.c.(*tls.Conn); ok {
+		ssl = "on"
+	}
 	return map[string]string{
+		"host":       c.session.server,
+		"packetSize": strconv.Itoa(c.session.packetSize),
+		"ssl":        ssl,
 		"server":     c.session.serverType,
 		"serverType": c.session.serverType,
 		"database":   c.session.database,
@@ -163,11 +243,53 @@ func (c Conn) GetEnv() map[string]string {
 	}
 }
 
+// InTransaction returns true if a transaction was left open
+// by the last command sent to the server.
+func (c Conn) InTransaction() bool {
+	return c.session.tranState == doneTranProgress
+}
+
+// AffectedRows returns the row count reported for the last statement
+// of the last command sent to the server, if any.
+func (c Conn) AffectedRows() (count int64, ok bool) {
+	return c.session.res.affectedRows, c.session.res.hasAffectedRows
+}
+
 // ErrorHandler is a connection which support defines sybase error handling
 type ErrorHandler interface {
 	SetErrorhandler(fn func(s SybError) bool)
 }
 
+// Dialer opens the network connections to the server,
+// to reach it through a tunnel for example.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// Connector opens the connections of a DSN with a custom dialer.
+// It is given to sql.OpenDB.
+type Connector struct {
+	dsn    string
+	dialer Dialer
+}
+
+// NewConnector returns a connector opening the connections to the DSN
+// with the dialer. The TLS handshake, if requested, is done over the
+// connection returned by the dialer.
+func NewConnector(dsn string, dialer Dialer) *Connector {
+	return &Connector{dsn: dsn, dialer: dialer}
+}
+
+// Connect implements driver.Connector
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return sybDriverInstance.open(c.dsn, c.dialer)
+}
+
+// Driver implements driver.Connector
+func (c *Connector) Driver() driver.Driver {
+	return sybDriverInstance
+}
+
 // register the driver
 type sybDriver struct {
 	sync.Mutex
@@ -177,9 +299,17 @@ type sybDriver struct {
 var sybDriverInstance = &sybDriver{}
 
 func (d *sybDriver) Open(dsn string) (driver.Conn, error) {
-	conn, err := NewConn(dsn)
-	if d.IsError != nil {
-		conn.SetErrorhandler(d.IsError)
+	return d.open(dsn, nil)
+}
+
+// open returns a connection opened with the given dialer, if any
+func (d *sybDriver) open(dsn string, dialer Dialer) (driver.Conn, error) {
+	d.Lock()
+	isError := d.IsError
+	d.Unlock()
+	conn, err := newConn(dsn, dialer)
+	if isError != nil {
+		conn.SetErrorhandler(isError)
 	}
 	return conn, err
 }
@@ -199,6 +329,7 @@ func init() {
 }
 
 var _ driver.Driver = (*sybDriver)(nil)
+var _ driver.Connector = (*Connector)(nil)
 
 // empty objects to return on error
 // Make sure the session is not nil to avoid nil pointers
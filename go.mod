@@ -5,6 +5,7 @@ require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/chzyer/test v0.0.0-20180213035817-a1ea475d72b1 // indirect
 	github.com/davecgh/go-spew v1.1.1
+	github.com/mattn/go-runewidth v0.0.4
 	github.com/xo/tblfmt v0.0.0-20190131174246-1761efe18ea6
 	golang.org/x/net v0.0.0-20190119204137-ed066c81e75e
 	golang.org/x/sys v0.0.0-20190124100055-b90733256f2e // indirect
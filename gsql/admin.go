@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// confirm asks the user for confirmation before a destructive action,
+// the answer defaulting to def. Set to the readline prompt in interactive sessions.
+// Nil in scripts, where the destructive actions must be forced explicitly.
+var confirm func(question string, def bool) bool
+
+// quote returns a string literal, with the quotes escaped
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// query runs a query and displays its results on the standard output
+func query(s *session, q string) error {
+	o := newOutput(stdout, "table")
+	defer o.Close()
+	return execBatch(s, o, q)
+}
+
+// whoCommand lists the user processes
+func whoCommand(s *session, args []string) error {
+	q := `select spid, status, login = suser_name(suid), hostname,
+		program = program_name, dbname = db_name(dbid), cmd,
+		blocked, time_blocked, cpu, io = physical_io
+	from master..sysprocesses
+	where suid > 0`
+	if len(args) > 0 {
+		q += " and suser_name(suid) = " + quote(args[0])
+	}
+	return query(s, q+" order by spid")
+}
+
+// locksCommand lists the locks held, optionally for a given spid
+func locksCommand(s *session, args []string) error {
+	q := `select l.spid, locktype = v.name, dbname = db_name(l.dbid),
+		object = object_name(l.id, l.dbid), l.page, l.row, l.class
+	from master..syslocks l, master..spt_values v
+	where l.type = v.number and v.type = 'L'`
+	if len(args) > 0 {
+		spid, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid spid '%s'", args[0])
+		}
+		q += fmt.Sprintf(" and l.spid = %d", spid)
+	}
+	return query(s, q+" order by l.spid, dbname, object")
+}
+
+// blocked process, as found in sysprocesses
+type process struct {
+	spid, blocked, timeBlocked int
+	login, cmd                 string
+}
+
+// blockingCommand displays the blocking chains as trees,
+// the head of each chain being the process blocking the others,
+// followed by the deadlocked processes, which have no head.
+func blockingCommand(s *session, args []string) error {
+	rows, err := s.conn.QueryContext(context.Background(),
+		`select spid, blocked, time_blocked, isnull(suser_name(suid), ''), cmd
+		from master..sysprocesses
+		where blocked > 0
+			or spid in (select blocked from master..sysprocesses where blocked > 0)
+		order by spid`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	procs := make(map[int]*process)
+	children := make(map[int][]int)
+	var spids []int
+	for rows.Next() {
+		p := &process{}
+		if err = rows.Scan(&p.spid, &p.blocked, &p.timeBlocked, &p.login, &p.cmd); err != nil {
+			return err
+		}
+		procs[p.spid] = p
+		spids = append(spids, p.spid)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(spids) == 0 {
+		fmt.Fprintln(stdout, "no blocked process")
+		return nil
+	}
+
+	for _, spid := range spids {
+		if p := procs[spid]; p.blocked != 0 {
+			children[p.blocked] = append(children[p.blocked], spid)
+		}
+	}
+
+	// members of deadlocked cycles are shown apart, with no head
+	cycles := deadlockCycles(procs, spids)
+	inCycle := make(map[int]bool)
+	for _, cycle := range cycles {
+		for _, spid := range cycle {
+			inCycle[spid] = true
+		}
+	}
+
+	var show func(spid, depth int)
+	show = func(spid, depth int) {
+		p := procs[spid]
+		switch {
+		case inCycle[spid]:
+			fmt.Fprintf(stdout, "%d %s (%s), blocked by %d for %ds\n", p.spid, strings.TrimSpace(p.cmd),
+				p.login, p.blocked, p.timeBlocked)
+		case p.blocked == 0 || depth == 0:
+			fmt.Fprintf(stdout, "%d %s (%s)\n", p.spid, strings.TrimSpace(p.cmd), p.login)
+		default:
+			fmt.Fprintf(stdout, "%s└─ %d %s (%s), blocked for %ds\n", strings.Repeat("   ", depth-1),
+				p.spid, strings.TrimSpace(p.cmd), p.login, p.timeBlocked)
+		}
+		for _, child := range children[spid] {
+			if !inCycle[child] {
+				show(child, depth+1)
+			}
+		}
+	}
+
+	// heads of chains are not blocked themselves
+	for _, spid := range spids {
+		if p := procs[spid]; p.blocked == 0 || procs[p.blocked] == nil {
+			show(spid, 0)
+		}
+	}
+
+	for _, cycle := range cycles {
+		members := make([]string, len(cycle))
+		for i, spid := range cycle {
+			members[i] = strconv.Itoa(spid)
+		}
+		fmt.Fprintf(stdout, "deadlock between %s\n", strings.Join(members, ", "))
+		for _, spid := range cycle {
+			show(spid, 0)
+		}
+	}
+	return nil
+}
+
+// deadlockCycles returns the processes blocking each other in a cycle,
+// each cycle starting with its lowest spid, in the order of spids.
+func deadlockCycles(procs map[int]*process, spids []int) (cycles [][]int) {
+	visited := make(map[int]bool)
+	for _, spid := range spids {
+		// follow the blockers until a known process or the head of the chain
+		var path []int
+		pos := make(map[int]int)
+		for cur := spid; procs[cur] != nil && !visited[cur]; cur = procs[cur].blocked {
+			visited[cur] = true
+			pos[cur] = len(path)
+			path = append(path, cur)
+			if i, ok := pos[procs[cur].blocked]; ok {
+				cycles = append(cycles, lowestFirst(path[i:]))
+				break
+			}
+		}
+	}
+	return cycles
+}
+
+// lowestFirst rotates the cycle to start with its lowest spid
+func lowestFirst(cycle []int) []int {
+	low := 0
+	for i, spid := range cycle {
+		if spid < cycle[low] {
+			low = i
+		}
+	}
+	return append(append([]int{}, cycle[low:]...), cycle[:low]...)
+}
+
+// killCommand kills a process, after confirmation unless forced
+func killCommand(s *session, args []string) error {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "force") {
+		return errors.New(`usage: \kill spid [force]`)
+	}
+	force := len(args) == 2
+	if !force && confirm == nil {
+		return errors.New(`\kill cannot be confirmed in a script, use \kill spid force`)
+	}
+	spid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid spid '%s'", args[0])
+	}
+
+	var login, cmd string
+	err = s.conn.QueryRowContext(context.Background(),
+		fmt.Sprintf(`select isnull(suser_name(suid), ''), cmd
+		from master..sysprocesses where spid = %d`, spid)).Scan(&login, &cmd)
+	if err != nil {
+		return fmt.Errorf("process %d not found", spid)
+	}
+
+	if !force && !confirm(fmt.Sprintf("kill process %d (%s, %s)?", spid, login, strings.TrimSpace(cmd)), false) {
+		return nil
+	}
+	_, err = s.conn.ExecContext(context.Background(), fmt.Sprintf("kill %d", spid))
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// the cycles are found whatever the process the walk starts from
+func TestDeadlockCycles(t *testing.T) {
+	for _, test := range []struct {
+		blocked  map[int]int
+		expected [][]int
+	}{
+		{map[int]int{1: 0, 2: 1, 3: 2}, nil},
+		{map[int]int{10: 12, 12: 10}, [][]int{{10, 12}}},
+		{map[int]int{5: 12, 10: 14, 12: 10, 14: 12}, [][]int{{10, 14, 12}}},
+		{map[int]int{1: 0, 2: 1, 7: 7, 8: 9, 9: 8}, [][]int{{7}, {8, 9}}},
+	} {
+		procs := make(map[int]*process)
+		var spids []int
+		for spid := 1; spid <= 20; spid++ {
+			if blocked, ok := test.blocked[spid]; ok {
+				procs[spid] = &process{spid: spid, blocked: blocked}
+				spids = append(spids, spid)
+			}
+		}
+		if cycles := deadlockCycles(procs, spids); !reflect.DeepEqual(cycles, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.blocked, test.expected, cycles)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/chzyer/readline"
+	runewidth "github.com/mattn/go-runewidth"
+	"github.com/xo/tblfmt"
+)
+
+// open the result sets taller or wider than the terminal in the browser
+var browse bool
+
+// browsing returns true if the result sets can be opened in the browser
+func browsing() bool {
+	return browse && !stream && !noHeader && !quiet && outputFile == "/gsqlnone/" && spoolFile == nil &&
+		readline.IsTerminal(int(os.Stdin.Fd())) && readline.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// replay reads a captured result set again
+type replay struct {
+	*capturedResult
+	i int
+}
+
+func (r *replay) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+
+func (r *replay) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		if p, ok := d.(*interface{}); ok {
+			*p = r.rows[r.i-1][i]
+			continue
+		}
+		v := reflect.ValueOf(d)
+		if v.Kind() != reflect.Ptr || r.rows[r.i-1][i] == nil {
+			return fmt.Errorf("unsupported scan destination %T", d)
+		}
+		v.Elem().Set(reflect.ValueOf(r.rows[r.i-1][i]))
+	}
+	return nil
+}
+
+func (r *replay) Columns() ([]string, error) { return r.columns, nil }
+func (r *replay) Close() error               { return nil }
+func (r *replay) Err() error                 { return nil }
+func (r *replay) NextResultSet() bool        { return false }
+
+// browseResult opens the current result set in the browser if it does not fit
+// on the screen. Otherwise, it returns a copy of the result set to display as usual.
+func (o *output) browseResult(rs *resultSet) (tblfmt.ResultSet, error) {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return rs, err
+	}
+	c := &capturedResult{columns: cols}
+	err = eachRow(rs, func(row []interface{}) error {
+		c.rows = append(c.rows, append([]interface{}(nil), row...))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBrowser(c)
+	if b.fits() {
+		return &replay{capturedResult: c}, nil
+	}
+
+	// keep the progress off the screen while browsing
+	if p := rs.progress; p != nil {
+		p.clear()
+		p.Lock()
+		defer p.Unlock()
+	}
+	if err = o.Flush(); err != nil {
+		return nil, err
+	}
+	return nil, b.run()
+}
+
+// browser displays a result set in a scrollable grid
+type browser struct {
+	r      *capturedResult
+	cells  [][]string
+	widths []int
+	hidden []bool
+
+	// cursor, and first row and column displayed
+	row, col  int
+	top, left int
+
+	width, height int
+	search        string
+	status        string
+}
+
+// maximum width of a column in the browser
+const browseColWidth = 40
+
+func newBrowser(r *capturedResult) *browser {
+	b := &browser{r: r, widths: make([]int, len(r.columns)), hidden: make([]bool, len(r.columns))}
+	for i, name := range r.columns {
+		b.widths[i] = runewidth.StringWidth(name)
+	}
+	flatten := strings.NewReplacer("\r\n", " ", "\n", " ", "\t", " ")
+	for _, row := range r.rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = flatten.Replace(formatValue(v))
+			if w := runewidth.StringWidth(cells[i]); w > b.widths[i] {
+				b.widths[i] = w
+			}
+		}
+		b.cells = append(b.cells, cells)
+	}
+	for i := range b.widths {
+		if b.widths[i] > browseColWidth {
+			b.widths[i] = browseColWidth
+		}
+	}
+	return b
+}
+
+// fits returns true if the result set can be displayed without scrolling
+func (b *browser) fits() bool {
+	w, h, err := readline.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return true
+	}
+	total := 1
+	for _, cw := range b.widths {
+		total += cw + 3
+	}
+	return total <= w && len(b.cells)+5 <= h
+}
+
+// run displays the grid until the user quits
+func (b *browser) run() error {
+	fd := int(os.Stdin.Fd())
+	state, err := readline.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer readline.Restore(fd, state)
+
+	// alternate screen, hidden cursor
+	fmt.Fprint(os.Stdout, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(os.Stdout, "\033[?25h\033[?1049l")
+
+	b.status = "arrows: move, /: search, n: next, -: hide column, +: show all, y: copy cell, q: quit"
+	buf := make([]byte, 16)
+	for {
+		if b.width, b.height, err = readline.GetSize(int(os.Stdout.Fd())); err != nil {
+			return err
+		}
+		b.draw()
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+		if !b.key(string(buf[:n])) {
+			return nil
+		}
+	}
+}
+
+// pageSize returns the number of rows displayed
+func (b *browser) pageSize() int {
+	if b.height < 3 {
+		return 1
+	}
+	return b.height - 2
+}
+
+// key handles a key press. Returns false to quit.
+func (b *browser) key(k string) bool {
+	b.status = ""
+	switch k {
+	case "q", "\033", "\x03":
+		return false
+	case "\033[A", "\033OA", "k":
+		b.row--
+	case "\033[B", "\033OB", "j", "\r":
+		b.row++
+	case "\033[D", "\033OD", "h":
+		b.moveCol(-1)
+	case "\033[C", "\033OC", "l", "\t":
+		b.moveCol(1)
+	case "\033[5~", "b", "\x02":
+		b.row -= b.pageSize()
+	case "\033[6~", " ", "\x06":
+		b.row += b.pageSize()
+	case "\033[H", "\033OH", "g":
+		b.row = 0
+	case "\033[F", "\033OF", "G":
+		b.row = len(b.cells) - 1
+	case "0":
+		b.col = -1
+		b.moveCol(1)
+	case "$":
+		b.col = len(b.widths)
+		b.moveCol(-1)
+	case "/":
+		b.search = b.prompt("/")
+		b.find()
+	case "n":
+		b.find()
+	case "-":
+		b.hide()
+	case "+":
+		b.hidden = make([]bool, len(b.widths))
+	case "y":
+		if len(b.cells) == 0 {
+			break
+		}
+		if err := copyToClipboard([]byte(formatValue(b.r.rows[b.row][b.col]))); err != nil {
+			b.status = err.Error()
+		} else {
+			b.status = "cell copied"
+		}
+	case "v":
+		if len(b.cells) > 0 {
+			b.status = formatValue(b.r.rows[b.row][b.col])
+		}
+	}
+
+	if b.row >= len(b.cells) {
+		b.row = len(b.cells) - 1
+	}
+	if b.row < 0 {
+		b.row = 0
+	}
+	return true
+}
+
+// moveCol moves the cursor to the next visible column in the given direction
+func (b *browser) moveCol(dir int) {
+	for c := b.col + dir; c >= 0 && c < len(b.widths); c += dir {
+		if !b.hidden[c] {
+			b.col = c
+			return
+		}
+	}
+}
+
+// hide hides the current column, unless it is the last one visible
+func (b *browser) hide() {
+	visible := 0
+	for _, h := range b.hidden {
+		if !h {
+			visible++
+		}
+	}
+	if visible <= 1 {
+		b.status = "cannot hide the last column"
+		return
+	}
+	b.hidden[b.col] = true
+	c := b.col
+	if b.moveCol(1); b.col == c {
+		b.moveCol(-1)
+	}
+}
+
+// prompt reads a line on the status line
+func (b *browser) prompt(p string) string {
+	var line []rune
+	buf := make([]byte, 16)
+	for {
+		fmt.Fprintf(os.Stdout, "\033[%d;1H\033[K%s%s", b.height, p, string(line))
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return ""
+		}
+		switch k := string(buf[:n]); k {
+		case "\r", "\n":
+			return string(line)
+		case "\033", "\x03":
+			return ""
+		case "\x7f", "\b":
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			if !strings.HasPrefix(k, "\033") {
+				line = append(line, []rune(k)...)
+			}
+		}
+	}
+}
+
+// find moves the cursor to the next visible cell containing the searched text
+func (b *browser) find() {
+	if b.search == "" || len(b.cells) == 0 {
+		return
+	}
+	search := strings.ToLower(b.search)
+	ncols := len(b.widths)
+	start := b.row*ncols + b.col
+	for i := 1; i <= len(b.cells)*ncols; i++ {
+		pos := (start + i) % (len(b.cells) * ncols)
+		r, c := pos/ncols, pos%ncols
+		if !b.hidden[c] && strings.Contains(strings.ToLower(b.cells[r][c]), search) {
+			b.row, b.col = r, c
+			return
+		}
+	}
+	b.status = "not found: " + b.search
+}
+
+// fit pads or truncates a value to the width of its column
+func fit(s string, width int) string {
+	if runewidth.StringWidth(s) > width {
+		return runewidth.Truncate(s, width, "…")
+	}
+	return runewidth.FillRight(s, width)
+}
+
+// draw redraws the whole screen
+func (b *browser) draw() {
+	// scroll to the cursor
+	if b.row < b.top {
+		b.top = b.row
+	}
+	if b.row >= b.top+b.pageSize() {
+		b.top = b.row - b.pageSize() + 1
+	}
+	if b.col < b.left {
+		b.left = b.col
+	}
+	for b.left < b.col && !b.visible(b.col) {
+		b.left++
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\033[H\033[2J")
+	line := func(cells []string, cursor int, header bool) {
+		var l strings.Builder
+		w := 0
+		for c := b.left; c < len(b.widths) && w < b.width; c++ {
+			if b.hidden[c] {
+				continue
+			}
+			v := fit(cells[c], b.widths[c])
+			if w+b.widths[c]+3 > b.width {
+				if b.width-w-3 < 1 {
+					break
+				}
+				v = fit(v, b.width-w-3)
+			}
+			switch {
+			case header:
+				v = "\033[1m" + v + "\033[0m"
+			case c == cursor:
+				v = "\033[7m" + v + "\033[0m"
+			}
+			l.WriteString(" " + v + " ")
+			w += b.widths[c] + 2
+			if w < b.width {
+				l.WriteString("│")
+				w++
+			}
+		}
+		sb.WriteString(l.String() + "\r\n")
+	}
+
+	line(b.r.columns, -1, true)
+	for r := b.top; r < len(b.cells) && r < b.top+b.pageSize(); r++ {
+		cursor := -1
+		if r == b.row {
+			cursor = b.col
+		}
+		line(b.cells[r], cursor, false)
+	}
+
+	status := b.status
+	if status == "" {
+		status = fmt.Sprintf("row %d/%d, column %s", b.row+1, len(b.cells), b.r.columns[b.col])
+		if len(b.cells) == 0 {
+			status = "no rows"
+		}
+	}
+	fmt.Fprintf(&sb, "\033[%d;1H\033[7m%s\033[0m", b.height, fit(status, b.width))
+	fmt.Fprint(os.Stdout, sb.String())
+}
+
+// visible returns true if a column is fully displayed, scrolled from the left column
+func (b *browser) visible(col int) bool {
+	w := 0
+	for c := b.left; c <= col; c++ {
+		if !b.hidden[c] {
+			w += b.widths[c] + 3
+		}
+	}
+	return w <= b.width
+}
+
+// browseCommand opens the last result set in the browser
+func browseCommand(s *session, args []string) error {
+	if lastResult == nil {
+		return errors.New("no result set to browse")
+	}
+	if !readline.IsTerminal(int(os.Stdin.Fd())) || !readline.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("the browser requires a terminal")
+	}
+	return newBrowser(lastResult).run()
+}
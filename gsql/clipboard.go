@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// capturedResult holds a copy of a result set, to render it again
+type capturedResult struct {
+	columns []string
+	rows    [][]interface{}
+	// rows were left out past maxCapturedRows
+	truncated bool
+}
+
+// maximum number of rows kept for \copy-last,
+// so that the large result sets are not held in memory
+const maxCapturedRows = 10000
+
+// add copies the values of a scanned row, up to maxCapturedRows
+func (c *capturedResult) add(dest []interface{}) {
+	if len(c.rows) >= maxCapturedRows {
+		c.truncated = true
+		return
+	}
+	row := make([]interface{}, len(dest))
+	for i, d := range dest {
+		if p, ok := d.(*interface{}); ok {
+			row[i] = *p
+		} else if v := reflect.ValueOf(d); v.Kind() == reflect.Ptr && !v.IsNil() {
+			row[i] = v.Elem().Interface()
+		}
+	}
+	c.rows = append(c.rows, row)
+}
+
+var (
+	// keep a copy of the last result set, in interactive mode only
+	keepLast bool
+	// last result set displayed
+	lastResult *capturedResult
+)
+
+// clipboard commands, tried in order
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip"}},
+	"linux": {{"wl-copy"}, {"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"}},
+}
+
+// copyToClipboard sends the text to the system clipboard
+func copyToClipboard(text []byte) error {
+	for _, args := range clipboardCommands[runtime.GOOS] {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(text)
+		return cmd.Run()
+	}
+	return fmt.Errorf("no clipboard command found for %s", runtime.GOOS)
+}
+
+// writeMarkdown writes a result set as a markdown table
+func writeMarkdown(w io.Writer, r *capturedResult) {
+	escape := strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+	line := func(values []string) {
+		fmt.Fprintf(w, "| %s |\n", strings.Join(values, " | "))
+	}
+
+	line(r.columns)
+	seps := make([]string, len(r.columns))
+	for i := range seps {
+		seps[i] = "---"
+	}
+	line(seps)
+	for _, row := range r.rows {
+		values := make([]string, len(row))
+		for i, v := range row {
+			values[i] = escape.Replace(formatValue(v))
+		}
+		line(values)
+	}
+}
+
+// copyLastCommand copies the last result set to the clipboard, as CSV or markdown
+func copyLastCommand(s *session, args []string) error {
+	format := "csv"
+	if len(args) > 0 {
+		format = args[0]
+	}
+	if lastResult == nil {
+		return errors.New("no result set to copy")
+	}
+
+	var b bytes.Buffer
+	switch format {
+	case "csv":
+		if err := writeCSV(&b, lastResult.columns); err != nil {
+			return err
+		}
+		record := make([]string, len(lastResult.columns))
+		for _, row := range lastResult.rows {
+			for i, v := range row {
+				record[i] = formatValue(v)
+			}
+			if err := writeCSV(&b, record); err != nil {
+				return err
+			}
+		}
+	case "markdown", "md":
+		writeMarkdown(&b, lastResult)
+	default:
+		return fmt.Errorf("unsupported format '%s', expected csv or markdown", format)
+	}
+
+	if err := copyToClipboard(b.Bytes()); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d rows copied\n", len(lastResult.rows))
+	if lastResult.truncated {
+		fmt.Fprintf(stdout, "only the first %d rows of the result set were kept\n", maxCapturedRows)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+
+	"github.com/chzyer/readline"
+)
+
+// ANSI color codes
+const (
+	colorRed     = "31"
+	colorBoldRed = "1;31"
+	colorYellow  = "33"
+	colorCyan    = "36"
+)
+
+// colorize wraps s in the given ANSI color when the output is a terminal.
+// Colors are disabled while spooling to keep escape codes out of the file.
+func colorize(color, s string) string {
+	if !useColor || spoolFile != nil || !readline.IsTerminal(int(os.Stdout.Fd())) {
+		return s
+	}
+	return "\033[" + color + "m" + s + "\033[0m"
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// command is a meta command, entered on a line starting with a backslash.
+// Meta commands are processed by gsql and are not sent to the server.
+type command struct {
+	usage string
+	run   func(s *session, args []string) error
+}
+
+var commands map[string]command
+
+// matches a plain word
+var wordRe = regexp.MustCompile(`^\w+$`)
+
+func init() {
+	commands = map[string]command{
+		"bcp":        {`\bcp table file [load options]`, bcpCommand},
+		"blocking":   {`\blocking`, blockingCommand},
+		"browse":     {`\browse`, browseCommand},
+		"check":      {`\check`, checkCommand},
+		"connect":    {`\connect [name|host:port]`, connectCommand},
+		"copy-last":  {`\copy-last [csv|markdown]`, copyLastCommand},
+		"disconnect": {`\disconnect [name]`, disconnectCommand},
+		"format":     {`\format [table|raw|csv|json|vertical|template]`, formatCommand},
+		"isolation":  {`\isolation [0|1|2|3]`, isolationCommand},
+		"kill":       {`\kill spid [force]`, killCommand},
+		"locks":      {`\locks [spid]`, locksCommand},
+		"nullvalue":  {`\nullvalue [text]`, nullValueCommand},
+		"on-all":     {`\on-all`, onAllCommand},
+		"option":     {`\option name [value]`, optionCommand},
+		"options":    {`\options`, optionsCommand},
+		"q":          {`\q`, quitCommand},
+		"quit":       {`\quit`, quitCommand},
+		"separator":  {`\separator [text]`, separatorCommand},
+		"set":        {`\set [name [value]]`, setCommand},
+		"showplan":   {`\showplan [on|off]`, showplanCommand},
+		"snip":       {`\snip [name [value...]]`, snipCommand},
+		"spool":      {`\spool [file|off]`, spoolCommand},
+		"stats":      {`\stats [on|off]`, statsCommand},
+		"terminator": {`\terminator [regexp]`, terminatorCommand},
+		"who":        {`\who [login]`, whoCommand},
+	}
+}
+
+// settings which can be changed at runtime with \set,
+// and the name of the flag they are bound to.
+var settings = map[string]string{
+	"datefmt":   "datefmt",
+	"maxrows":   "max-rows",
+	"nullvalue": "null-value",
+	"numfmt":    "numfmt",
+	"pagesize":  "p",
+	"separator": "s",
+	"theme":     "T",
+	"width":     "w",
+}
+
+// isCommand returns true if the batch is a meta command or a shell escape
+func isCommand(batch string) bool {
+	return strings.HasPrefix(batch, `\`) || strings.HasPrefix(batch, "!")
+}
+
+// runCommand parses a meta command and runs it
+func runCommand(s *session, line string) error {
+	// the shell gets the line as is
+	for _, prefix := range []string{"!", `\!`} {
+		if strings.HasPrefix(line, prefix) {
+			return shell(strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+		}
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, `\`))
+	if len(fields) == 0 {
+		return errors.New("empty command")
+	}
+	cmd, ok := commands[fields[0]]
+	if !ok {
+		return fmt.Errorf("unknown command \\%s", fields[0])
+	}
+	return cmd.run(s, fields[1:])
+}
+
+// shell runs a command with the user's shell
+func shell(command string) error {
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+	}
+	cmd := exec.Command(sh, "-c", command)
+	if command == "" {
+		cmd = exec.Command(sh)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, os.Stderr
+	return cmd.Run()
+}
+
+// terminatorCommand shows or changes the batch terminator.
+// A plain word only terminates the batch at the start of a line, like go.
+func terminatorCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "terminator is", terminator)
+		return nil
+	}
+	t := args[0]
+	if wordRe.MatchString(t) {
+		t = "^" + t
+	}
+	return setTerminator(t)
+}
+
+// parseOnOff parses a boolean command argument
+func parseOnOff(arg string) (bool, error) {
+	switch strings.ToLower(arg) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got '%s'", arg)
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// unquote removes the quotes around a command argument.
+// Values can be quoted, to set a space or an empty value for instance.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// setCommand shows or changes the runtime settings
+func setCommand(s *session, args []string) error {
+	// list all settings
+	if len(args) == 0 {
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stdout, "%s = %s\n", name, flag.Lookup(settings[name]).Value)
+		}
+		return nil
+	}
+
+	name, ok := settings[strings.ToLower(args[0])]
+	if !ok {
+		return fmt.Errorf("unknown setting '%s'", args[0])
+	}
+	f := flag.Lookup(name)
+
+	if len(args) == 1 {
+		fmt.Fprintf(stdout, "%s = %s\n", args[0], f.Value)
+		return nil
+	}
+
+	if err := f.Value.Set(unquote(strings.Join(args[1:], " "))); err != nil {
+		return fmt.Errorf("invalid value for %s: %s", args[0], err)
+	}
+	return nil
+}
+
+// formatCommand shows or changes the output format of the next batches
+func formatCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "output format is", outputFormat)
+		return nil
+	}
+	format := strings.ToLower(args[0])
+	switch {
+	case !outputFormats[format]:
+		return fmt.Errorf("unknown output format '%s'", args[0])
+	case format == "xlsx" || outputFormat == "xlsx":
+		return errors.New("the xlsx format cannot be switched during the session")
+	case format == "template" && rowTemplate == nil:
+		return errors.New("the template format requires --template")
+	}
+	outputFormat = format
+	return nil
+}
+
+// separatorCommand shows or changes the field separator of the current format:
+// the CSV delimiter, or the column separator of the raw output
+func separatorCommand(s *session, args []string) error {
+	if outputFormat != "csv" {
+		return setCommand(s, append([]string{"separator"}, args...))
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(stdout, "separator = %s\n", csvDelimiter)
+		return nil
+	}
+	d := unquote(strings.Join(args, " "))
+	if err := checkCSVDelimiter(d); err != nil {
+		return err
+	}
+	csvDelimiter = d
+	return nil
+}
+
+// nullValueCommand shows or changes the text written for the null values
+func nullValueCommand(s *session, args []string) error {
+	return setCommand(s, append([]string{"nullvalue"}, args...))
+}
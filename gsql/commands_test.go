@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// the separator is the CSV delimiter in csv, the column separator otherwise
+func TestSeparatorCommand(t *testing.T) {
+	defer func(format, d, sep string) {
+		outputFormat, csvDelimiter, columnSeparator = format, d, sep
+	}(outputFormat, csvDelimiter, columnSeparator)
+
+	for _, test := range []struct {
+		format    string
+		args      []string
+		delimiter string
+		separator string
+		hasError  bool
+	}{
+		{"csv", []string{";"}, ";", " ", false},
+		{"csv", []string{"'|'"}, "|", " ", false},
+		{"csv", []string{"'\t'"}, "\t", " ", false},
+		{"csv", []string{`"`}, ",", " ", true},
+		{"csv", []string{"ab"}, ",", " ", true},
+		{"raw", []string{"|"}, ",", "|", false},
+		{"table", []string{"' | '"}, ",", " | ", false},
+	} {
+		outputFormat, csvDelimiter, columnSeparator = test.format, ",", " "
+		err := separatorCommand(nil, test.args)
+		if (err != nil) != test.hasError {
+			t.Errorf("%s %v: expected error=%t, got %v", test.format, test.args, test.hasError, err)
+		}
+		if csvDelimiter != test.delimiter || columnSeparator != test.separator {
+			t.Errorf("%s %v: expected %q and %q, got %q and %q", test.format, test.args,
+				test.delimiter, test.separator, csvDelimiter, columnSeparator)
+		}
+	}
+}
+
+// without argument, the separator is shown
+func TestSeparatorCommandShow(t *testing.T) {
+	defer func(format, d string, w io.Writer) {
+		outputFormat, csvDelimiter, stdout = format, d, w
+	}(outputFormat, csvDelimiter, stdout)
+
+	var b bytes.Buffer
+	stdout = &b
+	outputFormat, csvDelimiter = "csv", ";"
+	if err := separatorCommand(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "separator = ;\n" {
+		t.Errorf("expected the delimiter to be shown, got %q", b.String())
+	}
+}
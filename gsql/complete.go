@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// keywords, functions and global variables common to ASE and SQL Anywhere
+var commonWords = []string{
+	"add", "all", "alter", "and", "any", "as", "asc", "at", "begin", "between",
+	"break", "browse", "by", "cascade", "case", "char", "check", "checkpoint",
+	"close", "commit", "compute", "constraint", "continue", "create", "cursor",
+	"database", "datetime", "deallocate", "declare", "default", "delete", "desc",
+	"distinct", "double", "drop", "else", "end", "exec", "execute", "exists",
+	"fetch", "for", "foreign", "from", "goto", "grant", "group", "having",
+	"holdlock", "identity", "if", "in", "index", "insert", "int", "integer",
+	"into", "is", "isolation", "join", "key", "left", "level", "like", "money",
+	"not", "null", "numeric", "of", "on", "open", "or", "order", "outer",
+	"precision", "primary", "print", "proc", "procedure", "raiserror", "read",
+	"references", "return", "revoke", "right", "rollback", "rowcount", "save",
+	"select", "set", "smallint", "table", "then", "tinyint", "to", "tran",
+	"transaction", "trigger", "truncate", "union", "unique", "update", "use",
+	"values", "varchar", "view", "waitfor", "when", "where", "while", "with",
+
+	"abs", "ascii", "avg", "ceiling", "char_length", "charindex", "coalesce",
+	"convert", "count", "datalength", "dateadd", "datediff", "datename",
+	"datepart", "db_id", "db_name", "floor", "getdate", "host_name", "isnull",
+	"len", "lower", "ltrim", "max", "min", "nullif", "object_id", "object_name",
+	"patindex", "power", "replicate", "reverse", "round", "rtrim", "space",
+	"sqrt", "str", "stuff", "substring", "sum", "suser_name", "upper",
+	"user_name",
+
+	"@@error", "@@identity", "@@isolation", "@@rowcount", "@@servername",
+	"@@spid", "@@trancount", "@@version",
+}
+
+// ASE words, by the major version introducing them
+var aseWords = map[int][]string{
+	0: {"at", "dump", "load", "noholdlock", "nolock", "readpast", "reconfigure",
+		"setuser", "shared", "readtext", "writetext", "updatetext", "tempdb",
+		"col_name", "col_length", "index_col", "lct_admin", "rowcnt",
+		"show_role", "str_replace", "syb_sendmsg", "tsequal", "valid_name",
+		"@@char_convert", "@@client_csname", "@@cpu_busy", "@@dbts",
+		"@@langid", "@@language", "@@lock_timeout", "@@max_connections",
+		"@@maxcharlen", "@@nestlevel", "@@options", "@@procid", "@@sqlstatus",
+		"@@textsize", "@@tranchained", "@@transtate"},
+	15: {"bigint", "unsigned", "partition", "roundrobin", "materialized",
+		"merge", "biginttohex", "hextobigint", "data_pages", "datachange",
+		"partition_id", "partition_name", "reserved_pages", "row_count",
+		"tran_dumpable_status", "used_pages", "@@cursor_rows",
+		"@@fetch_status", "@@instanceid", "@@instancename"},
+	16: {"replace", "compression", "index_compression", "lob_compression",
+		"hash", "@@bootcount", "@@heapmemsize", "@@jsonversion"},
+}
+
+// SQL Anywhere specific words
+var sqlAnywhereWords = []string{
+	"call", "current", "input", "limit", "message", "offset", "output",
+	"over", "partition", "recursive", "start", "unload", "window",
+	"dateformat", "days", "list", "locate", "now", "row_number", "string",
+	"today", "sa_conn_info", "sa_locks", "@@dbts", "@@procid",
+}
+
+// serverVersionRe extracts the major version from ASE's @@version
+var serverVersionRe = regexp.MustCompile(`Adaptive Server Enterprise/(\d+)`)
+
+// detectServer retrieves the server type and version
+// to complete with the proper words.
+func (s *session) detectServer() {
+	s.serverType = s.env()["serverType"]
+
+	var version string
+	if err := s.conn.QueryRowContext(context.Background(), "select @@version").Scan(&version); err != nil {
+		return
+	}
+	if m := serverVersionRe.FindStringSubmatch(version); m != nil {
+		s.serverVersion, _ = strconv.Atoi(m[1])
+	}
+}
+
+// completionWords returns the words to complete with for the session's server
+func (s *session) completionWords() []string {
+	if s.words != nil {
+		return s.words
+	}
+
+	s.words = append(s.words, commonWords...)
+	if strings.Contains(strings.ToLower(s.serverType), "anywhere") {
+		s.words = append(s.words, sqlAnywhereWords...)
+	} else {
+		for version, words := range aseWords {
+			if version <= s.serverVersion {
+				s.words = append(s.words, words...)
+			}
+		}
+	}
+	for name := range commands {
+		s.words = append(s.words, `\`+name)
+	}
+	sort.Strings(s.words)
+	return s.words
+}
+
+// completer completes the word under the cursor
+// with the keywords known for the current session's server
+type completer struct{}
+
+// isWordRune returns true for the runes which can be part of a completed word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '@' || r == '#' || r == '\\'
+}
+
+// Do implements readline.AutoCompleter
+func (completer) Do(line []rune, pos int) (candidates [][]rune, length int) {
+	start := pos
+	for start > 0 && isWordRune(line[start-1]) {
+		start--
+	}
+	prefix := string(line[start:pos])
+	if prefix == "" || current == nil {
+		return nil, 0
+	}
+
+	// keep the case of the typed word
+	upper := strings.ToUpper(prefix) == prefix && strings.ToLower(prefix) != prefix
+	lower := strings.ToLower(prefix)
+	last := ""
+	for _, word := range current.completionWords() {
+		if !strings.HasPrefix(word, lower) || word == last {
+			continue
+		}
+		last = word
+		if upper {
+			word = strings.ToUpper(word)
+		}
+		candidates = append(candidates, []rune(word[len(prefix):]))
+	}
+	return candidates, len([]rune(prefix))
+}
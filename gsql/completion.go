@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// flags taking a file name
+var fileFlags = map[string]bool{"i": true, "o": true, "I": true, "config": true, "login-script": true, "ssh-key": true, "query-log": true,
+	"ssl-ca": true, "ssl-cert": true, "ssl-key": true, "serve-cert": true, "serve-key": true}
+
+// flagValues returns the values proposed for a flag, if any
+func flagValues(name string) []string {
+	var values []string
+	switch name {
+	case "S":
+		for alias := range cfg.Servers {
+			values = append(values, alias)
+		}
+	case "m", "F":
+		for format := range outputFormats {
+			values = append(values, format)
+		}
+	case "T":
+		values = []string{"ASCIICompact", "UtfCompact", "UtfDouble"}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// completionScript writes the completion script of a shell,
+// for the flags and the server aliases of the configuration file.
+func completionScript(w io.Writer, shell string) error {
+	var flags []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+
+	switch shell {
+	case "bash":
+		fmt.Fprint(w, "_gsql() {\n\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+		fmt.Fprint(w, "\tcase \"$prev\" in\n")
+		var names []string
+		for _, f := range flags {
+			names = append(names, "-"+f.Name)
+			if fileFlags[f.Name] {
+				fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -f -- \"$cur\")); return;;\n", f.Name)
+			} else if values := flagValues(f.Name); len(values) > 0 {
+				fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return;;\n",
+					f.Name, strings.Join(values, " "))
+			}
+		}
+		fmt.Fprint(w, "\tesac\n")
+		fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n}\n", strings.Join(names, " "))
+		fmt.Fprint(w, "complete -F _gsql gsql\n")
+
+	case "zsh":
+		fmt.Fprint(w, "#compdef gsql\n\n_arguments \\\n")
+		for _, f := range flags {
+			usage := strings.NewReplacer("[", "(", "]", ")", "'", "", ":", " ").Replace(f.Usage)
+			action := ""
+			if fileFlags[f.Name] {
+				action = ":file:_files"
+			} else if values := flagValues(f.Name); len(values) > 0 {
+				action = fmt.Sprintf(":%s:(%s)", f.Name, strings.Join(values, " "))
+			} else if !isBoolFlag(f) {
+				action = ":" + f.Name + ": "
+			}
+			fmt.Fprintf(w, "\t'-%s[%s]%s' \\\n", f.Name, usage, action)
+		}
+		fmt.Fprint(w, "\t'1:command:(completion load schemadiff)'\n")
+
+	case "fish":
+		for _, f := range flags {
+			usage := strings.Replace(f.Usage, "'", `\'`, -1)
+			opts := ""
+			if fileFlags[f.Name] {
+				opts = " -r -F"
+			} else if values := flagValues(f.Name); len(values) > 0 {
+				opts = fmt.Sprintf(" -x -a '%s'", strings.Join(values, " "))
+			} else if !isBoolFlag(f) {
+				opts = " -x"
+			}
+			fmt.Fprintf(w, "complete -c gsql -o %s -d '%s'%s\n", f.Name, usage, opts)
+		}
+
+	default:
+		return fmt.Errorf("unsupported shell '%s', expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+// isBoolFlag returns true for the flags which do not take a value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// serverConfig describes a server alias.
+// Empty fields default to the command line parameters.
+type serverConfig struct {
+	Server   string `json:"server"` // host:port
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+	// batches run after each connection, overriding the global one
+	LoginScript string `json:"loginScript"`
+}
+
+// config is the content of the configuration file
+type config struct {
+	Servers map[string]serverConfig `json:"servers"`
+	// batches run after each connection
+	LoginScript string `json:"loginScript"`
+	// named queries, run with \snip
+	Snippets map[string]string `json:"snippets"`
+}
+
+var cfg config
+
+// defaultConfigFile returns the path of the configuration file in the home directory
+func defaultConfigFile() string {
+	usr, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(usr.HomeDir, ".gsql.json")
+}
+
+// loadConfig reads the configuration file. A missing file is not an error.
+func loadConfig(path string) error {
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %s", path, err)
+	}
+	return nil
+}
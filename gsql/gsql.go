@@ -10,14 +10,15 @@ import (
 	"io"
 	"net/url"
 	"os"
-	"os/signal"
 	"os/user"
 	"regexp"
+	"strconv"
 	"strings"
-	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/thda/tds"
-	"github.com/xo/tblfmt"
+	"golang.org/x/text/encoding"
 
 	"github.com/chzyer/readline"
 )
@@ -46,8 +47,98 @@ var (
 	locale          string
 	width           int
 	ssl             = "off"
-	theme           = "UtfCompact"
+	theme           = themeName("UtfCompact")
 	re              *regexp.Regexp
+	// number of executions of the batch, given after the terminator
+	batchCount = 1
+
+	// single transaction mode
+	singleTransaction bool
+	rollbackSeverity  = 11
+	// highest severity of the server messages received for the current batch
+	batchSeverity int
+
+	// maximum number of rows fetched per result set
+	maxRows int
+
+	// column width limits
+	maxColWidth int
+	colWidths   = columnWidths{}
+	wrap        bool
+
+	showProgress = true
+	useColor     = true
+
+	configFile = defaultConfigFile()
+
+	// display format of dates and numbers
+	dateFormat = "2006-01-02 15:04:05"
+	numFmt     = &numFormat{}
+
+	// tls settings
+	sslCA, sslServerName, sslCert, sslKey string
+	sslSkipVerify                         bool
+
+	interfacesFile string
+
+	// require the password encryption
+	encryptPassword bool
+
+	// csv settings
+	csvHeader    = true
+	csvQuote     = `"`
+	csvQuoteAll  bool
+	csvCRLF      bool
+	csvDelimiter = ","
+
+	// text written for the null values
+	nullValue string
+
+	// character set of the results
+	outputCharset  string
+	outputEncoding encoding.Encoding
+
+	// address to serve queries over HTTP on, and the certificate to serve HTTPS
+	serveAddr, serveCert, serveKey string
+
+	// messages of a lower severity are not displayed
+	minSeverity int
+
+	// values of the :name placeholders, and file giving them for each execution
+	params    = paramValues{}
+	paramFile string
+	csvParams []paramValues
+
+	// batches run after each connection
+	loginScript string
+
+	// times a batch is run again after a deadlock or a connection loss
+	retryDeadlock int
+
+	// maximum duration of the whole run, in seconds, and the context
+	// cancelled when it expires
+	totalTimeout int
+	runCtx       = context.Background()
+
+	// ssh bastion to reach the servers through, and its identity file
+	sshBastion, sshKey string
+
+	// write the rows as they arrive, without alignment
+	stream bool
+
+	// keep the terminal output when the output is not a terminal
+	pretty bool
+
+	// print a summary of the result sets instead of the rows
+	summary bool
+
+	// only check the connection
+	check bool
+
+	compress     bool
+	outputFormat = "table"
+	splitPrefix  string
+	quiet        bool
 )
 
 func usage() {
@@ -70,21 +161,99 @@ func init() {
 	flag.StringVar(&hostname, "H", "system hostname", "client's host name to send to the server.")
 	flag.StringVar(&inputFile, "i", "/gsqlnone/", "file to read commands from")
 	flag.StringVar(&charset, "J", charset, "character set")
-	flag.StringVar(&theme, "T", theme, "display theme, can be ASCIICompact or UtfCompact")
+	flag.Var(&theme, "T", "display theme, can be ASCIICompact, UtfCompact or UtfDouble")
 	flag.IntVar(&loginTimeout, "l", 0, "login Timeout")
 	flag.StringVar(&outputFile, "o", "/gsqlnone/", "file to output to")
 	flag.StringVar(&password, "P", "none", "password")
 	flag.IntVar(&pageSize, "p", pageSize, "paging size")
 	flag.StringVar(&columnSeparator, "s", columnSeparator, "column separator")
-	flag.StringVar(&server, "S", " ", "host:port")
-	flag.IntVar(&commandTimeout, "t", 0, "command Timeout")
+	flag.StringVar(&server, "S", " ", "host:port, alias of the configuration file or server name in the interfaces file. Fallback servers can follow, comma separated")
+	flag.StringVar(&sshBastion, "ssh", "", "connect through an ssh tunnel to this bastion, as user@host[:port]")
+	flag.StringVar(&sshKey, "ssh-key", "", "identity file of the ssh tunnel. Defaults to the agent and the ssh configuration")
+	flag.StringVar(&interfacesFile, "I", "", "interfaces file. Defaults to $SYBASE/interfaces")
+	flag.IntVar(&commandTimeout, "t", 0, "command timeout in seconds, the batches running longer are cancelled. Zero for no timeout")
+	flag.IntVar(&totalTimeout, "total-timeout", 0, "timeout of the whole run in seconds, the running batch being cancelled. Zero for no timeout")
 	flag.IntVar(&width, "w", 0, "line width")
 	flag.StringVar(&userName, "U", "none", "user name")
 	flag.StringVar(&ssl, "x", ssl, "Set to 'on' to enable ssl")
+	flag.BoolVar(&encryptPassword, "X", false, "require the encryption of the password at login")
 	flag.StringVar(&locale, "z", "none", "locale name")
+	flag.BoolVar(&singleTransaction, "single-transaction", false, "wrap the input file in a single transaction, rolled back on error")
+	flag.IntVar(&rollbackSeverity, "rollback-severity", rollbackSeverity, "minimum message severity causing a rollback in single transaction mode")
+	flag.StringVar(&queryLogFile, "query-log", "", "append the batches run, with their duration, row counts and outcome, to this file as JSON lines")
+	flag.IntVar(&retryDeadlock, "retry-deadlock", 0, "run the batches failing on a deadlock or a connection loss again, up to this number of times")
+	flag.IntVar(&maxRows, "max-rows", 0, "maximum number of rows fetched per result set, the batch being cancelled past it. Zero for no limit")
+	flag.IntVar(&maxColWidth, "max-col-width", 0, "maximum width of a column. Zero to derive it from the line width")
+	flag.Var(colWidths, "col-width", "maximum width of specific columns, as a comma separated list of name=width")
+	flag.BoolVar(&wrap, "wrap", false, "wrap long values instead of truncating them")
+	flag.BoolVar(&showProgress, "progress", showProgress, "display the elapsed time and the rows fetched on stderr during long queries")
+	flag.BoolVar(&useColor, "color", useColor, "colorize the output on terminals")
+	flag.StringVar(&configFile, "config", configFile, "configuration file")
+	flag.StringVar(&loginScript, "login-script", "", "file whose batches are run after each connection, before the input")
+	flag.StringVar(&dateFormat, "datefmt", dateFormat, "display format of dates, as a go time layout")
+	flag.Var(numFmt, "numfmt", "display format of numbers, as a pattern such as #,##0.00")
+	flag.BoolVar(&compress, "compress", false, "gzip the output file. Implied when its name ends with .gz")
+	flag.StringVar(&outputFormat, "m", outputFormat, "output format: table, raw, csv, json (one object per row), vertical, xlsx or template. Defaults to table on terminals, csv otherwise")
+	flag.StringVar(&outputFormat, "F", outputFormat, "output format, same as -m")
+	flag.StringVar(&templateText, "template", "", "go template of the rows in the template format, such as '{{.id}}|{{date \"2006-01-02\" .created}}\\n'")
+	flag.BoolVar(&csvHeader, "csv-header", csvHeader, "write the column names as the first CSV record")
+	flag.StringVar(&csvQuote, "csv-quote", csvQuote, "quote character of the CSV fields")
+	flag.BoolVar(&csvQuoteAll, "csv-quote-all", false, "quote all the CSV fields, not only the ones requiring it")
+	flag.BoolVar(&csvCRLF, "csv-crlf", false, "end the CSV records with CRLF instead of LF")
+	flag.StringVar(&csvDelimiter, "csv-delimiter", csvDelimiter, "field delimiter of the CSV records")
+	flag.StringVar(&nullValue, "null-value", "", "text written for the null values, except in json")
+	flag.StringVar(&outputCharset, "output-charset", "", "character set of the results, if not utf8. Sybase or IANA name")
+	flag.IntVar(&minSeverity, "min-severity", 0, "do not display the server messages below this severity")
+	flag.Var(params, "param", "value of a :name placeholder, as name=value. Can be repeated")
+	flag.StringVar(&paramFile, "param-csv", "", "CSV file giving the placeholders' values, the batches being run once per record")
+	flag.BoolVar(&browse, "browse", false, "open the result sets which do not fit on the terminal in a scrollable browser")
+	flag.BoolVar(&pretty, "pretty", false, "keep the tables, colors and progress when the output is not a terminal. By default, the output is csv, or guessed from the -o extension")
+	flag.BoolVar(&stream, "stream", false, "write the rows as they arrive, without aligning the columns, to export huge result sets")
+	flag.BoolVar(&summary, "summary", false, "print the row count, size and an order insensitive checksum of each result set instead of the rows")
+	flag.BoolVar(&check, "check", false, "check the connection, print its details and exit")
+	flag.StringVar(&serveAddr, "serve", "", "serve queries over HTTP on this address, such as :8080 for localhost only. The token is read from $GSQL_SERVE_TOKEN")
+	flag.StringVar(&serveCert, "serve-cert", "", "PEM certificate to serve HTTPS with --serve")
+	flag.StringVar(&serveKey, "serve-key", "", "PEM key of the --serve-cert certificate")
+	flag.StringVar(&splitPrefix, "split-output", "", "write each result set to its own file, named prefix_001.csv and so on")
+	flag.BoolVar(&quiet, "quiet", false, "only print the data rows: no headers, row counts or informational messages")
+	flag.StringVar(&sslCA, "ssl-ca", "", "PEM file of the certificate authorities used to verify the server")
+	flag.BoolVar(&sslSkipVerify, "ssl-skip-verify", false, "do not verify the server's certificate")
+	flag.StringVar(&sslServerName, "ssl-servername", "", "name expected in the server's certificate, if not the host name")
+	flag.StringVar(&sslCert, "ssl-cert", "", "PEM file of the client certificate")
+	flag.StringVar(&sslKey, "ssl-key", "", "PEM file of the client certificate's key")
+}
+
+// parseFlags parses the command line and checks the settings
+func parseFlags() {
 	flag.Parse()
 
-	re = regexp.MustCompile("(" + terminator + ")$")
+	// any tls setting enables ssl
+	if sslCA != "" || sslSkipVerify || sslServerName != "" || sslCert != "" || sslKey != "" {
+		ssl = "on"
+	}
+
+	if quiet {
+		noHeader = true
+	}
+
+	if utf8.RuneCountInString(csvQuote) != 1 {
+		fmt.Fprintln(os.Stderr, "the CSV quote must be a single character")
+		os.Exit(1)
+	}
+	if err := checkCSVDelimiter(csvDelimiter); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := setTerminator(terminator); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := checkBastion(sshBastion); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	// check for mandatory parameters
 	if userName == "" || server == "" {
@@ -95,7 +264,7 @@ func init() {
 }
 
 // build the connection string
-func buildCnxStr() string {
+func buildCnxStr(t target) string {
 	// build the url
 	v := url.Values{}
 	if chained {
@@ -106,20 +275,52 @@ func buildCnxStr() string {
 	}
 	if ssl == "on" {
 		v.Set("ssl", "on")
+		// only when given, the driver's default depends on sslCA
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "ssl-skip-verify" {
+				v.Set("sslSkipVerify", strconv.FormatBool(sslSkipVerify))
+			}
+		})
+		for param, value := range map[string]string{"sslCA": sslCA,
+			"sslServerName": sslServerName, "sslCert": sslCert, "sslKey": sslKey} {
+			if value != "" {
+				v.Set(param, value)
+			}
+		}
+	}
+	if encryptPassword {
+		v.Set("encryptPassword", "yes")
 	}
 	v.Set("hostname", hostname)
 	v.Set("readTimeout", "10")
 	if charset != "" {
 		v.Set("charset", charset)
 	}
-	return "tds://" + url.QueryEscape(userName) + ":" + url.QueryEscape(password) +
-		"@" + server + "/" + url.QueryEscape(database) + "?" + v.Encode()
+	return "tds://" + url.QueryEscape(t.user) + ":" + url.QueryEscape(t.password) +
+		"@" + t.server + "/" + url.QueryEscape(t.database) + "?" + v.Encode()
+}
+
+// setTerminator changes the batch terminator.
+// The terminator can be followed by a count of executions of the batch, as in "go 10"
+func setTerminator(t string) error {
+	r, err := regexp.Compile("(" + t + `)(?:\s+(\d+))?\s*$`)
+	if err != nil {
+		return fmt.Errorf("invalid terminator '%s': %s", t, err)
+	}
+	terminator, re = t, r
+	return nil
 }
 
 // find the string terminator in a line and add it to the current batch if needed
 func processLine(terminator string, line string, batch string) (batchOut string, found bool) {
+	// meta commands are processed straight away
+	if batch == "" && isCommand(strings.TrimSpace(line)) {
+		return strings.TrimSpace(line), true
+	}
+
 	// continue till we get a the terminator
-	if match, _ := regexp.MatchString(terminator+"$", line); !match {
+	m := re.FindStringSubmatchIndex(line)
+	if m == nil {
 		if batch == "" {
 			batchOut = line
 		} else {
@@ -128,7 +329,18 @@ func processLine(terminator string, line string, batch string) (batchOut string,
 		}
 		return batchOut, false
 	}
-	return batch + re.ReplaceAllString(line, ""), true
+
+	batchCount = 1
+	if m[4] >= 0 {
+		batchCount, _ = strconv.Atoi(line[m[4]:m[5]])
+	}
+	if line = line[:m[0]]; line == "" {
+		return batch, true
+	}
+	if batch == "" {
+		return line, true
+	}
+	return batch + "\n" + line, true
 }
 
 type SQLBatchReader interface {
@@ -140,6 +352,11 @@ type fileBatchReader struct {
 	io.ReadCloser
 	scanner *bufio.Reader
 	w       *bufio.Writer
+
+	// file name, lines read so far and first line of the last batch
+	name  string
+	line  int
+	start int
 }
 
 func (r *fileBatchReader) ReadBatch(terminator string) (batch string, err error) {
@@ -151,6 +368,13 @@ func (r *fileBatchReader) ReadBatch(terminator string) (batch string, err error)
 		if err != nil && (err != io.EOF || line == "") {
 			return batch, err
 		}
+		r.line++
+		line = strings.TrimRight(line, "\r\n")
+
+		// leading blank lines are not sent to the server
+		if batch == "" {
+			r.start = r.line
+		}
 		batch, found = processLine(terminator, line, batch)
 
 		// found the separator
@@ -160,7 +384,7 @@ func (r *fileBatchReader) ReadBatch(terminator string) (batch string, err error)
 		}
 
 		if echoInput {
-			fmt.Printf("%d> %s", lineNo, line)
+			fmt.Fprintf(stdout, "%d> %s\n", lineNo, line)
 		}
 		lineNo++
 	}
@@ -168,7 +392,7 @@ func (r *fileBatchReader) ReadBatch(terminator string) (batch string, err error)
 
 // get an instance of readline with the proper settings
 func newFileBatchReader(inputFile string, w *bufio.Writer) (r *fileBatchReader, err error) {
-	r = &fileBatchReader{w: w}
+	r = &fileBatchReader{w: w, name: inputFile}
 	if r.ReadCloser, err = os.Open(inputFile); err != nil {
 		return nil, err
 	}
@@ -178,8 +402,8 @@ func newFileBatchReader(inputFile string, w *bufio.Writer) (r *fileBatchReader,
 
 type readLineBatchReader struct {
 	*readline.Instance
-	server string
-	conn   *sql.DB
+	server    string
+	isolation int
 }
 
 func (r *readLineBatchReader) ReadBatch(terminator string) (batch string, err error) {
@@ -187,16 +411,28 @@ func (r *readLineBatchReader) ReadBatch(terminator string) (batch string, err er
 	lineNo := 1
 	for {
 		var prompt string
-		row := r.conn.QueryRow("select @@servername")
-		if err == nil {
-			row.Scan(&r.server)
-		}
+		var trancount int
+		// the plan and the statistics of the query are not the user's
+		mute(func() {
+			current.conn.QueryRowContext(context.Background(), "select @@servername, @@isolation, @@trancount").
+				Scan(&r.server, &r.isolation, &trancount)
+		})
 
 		prompt = fmt.Sprintf("%d $ ", lineNo)
 		if r.server != "" {
 			prompt = fmt.Sprintf("%s %d $ ", r.server, lineNo)
 		}
 
+		// show the isolation level when not the default one
+		if r.isolation != 1 {
+			prompt = fmt.Sprintf("[iso %d] %s", r.isolation, prompt)
+		}
+
+		// flag the open transactions, which are easily forgotten
+		if current.inTransaction() {
+			prompt = fmt.Sprintf("[T%d] %s", trancount, prompt)
+		}
+
 		r.SetPrompt(prompt)
 		line, err := r.Readline()
 
@@ -220,12 +456,13 @@ func (r *readLineBatchReader) ReadBatch(terminator string) (batch string, err er
 }
 
 // get an instance of readline with the proper settings
-func newReadLineBatchReader(conn *sql.DB) (SQLBatchReader, error) {
+func newReadLineBatchReader() (SQLBatchReader, error) {
 	usr, _ := user.Current()
 	rl, err := readline.NewEx(&readline.Config{
 		Prompt:                 "$ ",
 		HistoryFile:            usr.HomeDir + "/.gsql_history.txt",
 		DisableAutoSaveHistory: true,
+		AutoComplete:           completer{},
 	})
 
 	rl.SetPrompt("1> ")
@@ -234,125 +471,469 @@ func newReadLineBatchReader(conn *sql.DB) (SQLBatchReader, error) {
 		return nil, fmt.Errorf("newReadLine: error while initiating readline object (%s)", err)
 	}
 
-	return &readLineBatchReader{Instance: rl, conn: conn}, err
+	return &readLineBatchReader{Instance: rl, isolation: 1}, err
+}
+
+// ask prompts for a value, proposing a default one
+func (r *readLineBatchReader) ask(question, def string) (string, error) {
+	r.SetPrompt(question + ": ")
+	return r.ReadlineWithDefault(def)
+}
+
+// confirm asks a yes/no question, an empty answer giving def
+func (r *readLineBatchReader) confirm(question string, def bool) bool {
+	choices := " [y/N] "
+	if def {
+		choices = " [Y/n] "
+	}
+	r.SetPrompt(question + choices)
+	answer, err := r.Readline()
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// rollback aborts the single transaction after a failed batch,
+// and reports the offending statement.
+func rollback(conn *sql.Conn, batchNo int, batch string) {
+	fmt.Fprintf(os.Stderr, "gsql: %sbatch %d failed, rolling back transaction:\n%s\n", location(0), batchNo, batch)
+	if _, err := conn.ExecContext(context.Background(), "if @@trancount > 0 rollback tran"); err != nil {
+		if _, ok := err.(tds.SybError); !ok {
+			fmt.Fprintln(os.Stderr, "gsql: rollback failed:", err)
+		}
+	}
 }
 
 func main() {
+	parseFlags()
+	os.Exit(run())
+}
+
+// execBatch sends a batch to the server and displays its results
+func execBatch(s *session, o *output, batch string, args ...interface{}) error {
+	// handle cancelation. The driver cancels the batch on the server
+	// when the command or the total timeout expires.
+	parent := runCtx
+	if s.ctx != nil {
+		parent = s.ctx
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	if commandTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(commandTimeout)*time.Second)
+		defer cancel()
+	}
+
+	// Ctrl-C cancels the batch until its results are displayed
+	end := startBatch(cancel)
+
+	// send query
+	start := time.Now()
+	o.rows = 0
+	p := startProgress()
+	var rows *sql.Rows
+	var err error
+	if s.stmt != nil && s.stmtQuery == batch {
+		rows, err = s.stmt.QueryContext(ctx, args...)
+	} else {
+		rows, err = s.conn.QueryContext(ctx, batch, args...)
+	}
+	if err == nil {
+		err = o.render(rows, p, cancel)
+		rows.Close()
+		o.Flush()
+	}
+	p.Stop()
+	printPlan()
+	stats.print()
+
+	if end() {
+		err = errCancelled
+		// the connection is unusable if the attention failed
+		if !s.alive() {
+			if rerr := s.reconnect(); rerr != nil {
+				fmt.Fprintln(stdout, "failed to reconnect:", rerr)
+			} else {
+				fmt.Fprintln(stdout, "reconnected")
+			}
+		}
+	}
+
+	logBatch(s, batch, start, o.rows, err)
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("batch cancelled after the command timeout of %ds", commandTimeout)
+		if runCtx.Err() != nil {
+			err = fmt.Errorf("batch cancelled after the total timeout of %ds", totalTimeout)
+		}
+	}
+
+	if err != nil {
+		// SQL errors are printed by the error handler
+		if _, ok := err.(tds.SybError); !ok {
+			fmt.Fprintln(stdout, location(0)+err.Error())
+		}
+	}
+	return err
+}
+
+// retryable returns true for the errors worth running the batch again:
+// deadlocks and lost connections
+func retryable(s *session, err error) bool {
+	if e, ok := err.(tds.SybError); ok {
+		return e.MsgNumber == 1205
+	}
+	return !s.alive()
+}
+
+// execRetry runs a batch, running it again after deadlocks and connection losses
+// up to --retry-deadlock times, waiting longer between each try.
+// A transaction spanning several batches cannot be retried: the server
+// rolled back the whole transaction, not only the batch.
+func execRetry(s *session, o *output, batch string, args ...interface{}) (err error) {
+	inTransaction := singleTransaction || s.inTransaction()
+	ctx := runCtx
+	if s.ctx != nil {
+		ctx = s.ctx
+	}
+
+	for try := 1; ; try++ {
+		if err = execBatch(s, o, batch, args...); err == nil || try > retryDeadlock ||
+			inTransaction || ctx.Err() != nil || err == errCancelled || !retryable(s, err) {
+			return err
+		}
+
+		delay := time.Duration(1<<uint(try-1)) * time.Second
+		fmt.Fprintf(os.Stderr, "%sretry %d of %d in %s: %s\n", location(0), try, retryDeadlock, delay,
+			strings.TrimSpace(err.Error()))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		if !s.alive() {
+			if rerr := s.reconnect(); rerr != nil {
+				fmt.Fprintln(os.Stderr, "failed to reconnect:", rerr)
+			}
+		}
+		batchSeverity = 0
+	}
+}
+
+func run() int {
 	// defer profile.Start(profile.CPUProfile).Stop()
 	var batch string
 	var r SQLBatchReader
-	var w *bufio.Writer
+	var out *output
+	var err error
 
-	// connect
-	conn, err := sql.Open("tds", buildCnxStr())
-	if err != nil {
+	if err = loadConfig(configFile); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// gsql completion bash|zsh|fish
+	if flag.Arg(0) == "completion" {
+		if err = completionScript(os.Stdout, flag.Arg(1)); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return 0
+	}
+
+	autoFormat()
+	if !outputFormats[outputFormat] {
+		fmt.Println("invalid output format", outputFormat)
+		return 1
+	}
+	if outputFormat == "xlsx" && stream {
+		fmt.Println("the xlsx format cannot be streamed")
+		return 1
+	}
+	if outputFormat == "xlsx" && outputFile == "/gsqlnone/" && splitPrefix == "" {
+		fmt.Println("the xlsx format requires an output file")
+		return 1
+	}
+
+	if outputFormat == "template" {
+		if templateText == "" {
+			fmt.Println("the template format requires --template")
+			return 1
+		}
+		if err = parseTemplate(templateText); err != nil {
+			fmt.Println("invalid template:", err)
+			return 1
+		}
+	}
+
+	if queryLogFile != "" {
+		if err = openQueryLog(queryLogFile); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer queryLog.Close()
+	}
+
+	if paramFile != "" {
+		if csvParams, err = paramRows(paramFile); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	if outputCharset != "" {
+		if outputEncoding, err = lookupEncoding(outputCharset); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	if flag.Arg(0) == "schemadiff" {
+		defer closeSessions()
+		if err = schemaDiffCommand(flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return 0
+	}
+
+	// connect. Use a single connection to keep the session's state
+	// (database, options, transactions) between batches.
+	t := defaultTarget()
+	if _, ok := cfg.Servers[server]; ok {
+		t, _ = lookupTarget(server)
+	}
+	if current, err = newSession(server, t); err != nil {
 		fmt.Println("failed to connect: ", err)
-		os.Exit(1)
+		return 1
 	}
+	defer closeSessions()
 
-	// print showplan messages and all
-	conn.Driver().(tds.ErrorHandler).SetErrorhandler(func(m tds.SybError) bool {
-		if m.Severity == 10 {
-			if (m.MsgNumber >= 3612 && m.MsgNumber <= 3615) ||
-				(m.MsgNumber >= 6201 && m.MsgNumber <= 6299) ||
-				(m.MsgNumber >= 10201 && m.MsgNumber <= 10299) {
-				fmt.Printf(m.Message)
-			} else {
-				fmt.Println(strings.TrimRight(m.Message, "\n"))
-			}
+	if check {
+		if err = checkCommand(current, nil); err != nil {
+			fmt.Println(err)
+			return 1
 		}
+		return 0
+	}
 
-		if m.Severity > 10 {
-			fmt.Print(m)
+	if flag.Arg(0) == "load" {
+		if err = loadCommand(current, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			return 1
 		}
-		return m.Severity > 10
-	})
+		return 0
+	}
+
+	if serveAddr != "" {
+		if (serveCert == "") != (serveKey == "") {
+			fmt.Println("--serve-cert and --serve-key go together")
+			return 1
+		}
+		showProgress = false
+		if err = serve(current, serveAddr); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return 0
+	}
 
 	// open outpout
 	switch outputFile {
 	default:
-		var f io.WriteCloser
-		var _, err = os.Stat(outputFile)
-		if os.IsNotExist(err) {
-			// not found...
-			f, err = os.Create(outputFile)
-		} else {
-			// truncate the file if it exists
-			if err = os.Truncate(outputFile, 0); err == nil {
-				f, err = os.Open(outputFile)
-			}
-		}
+		f, err := openOutput(outputFile)
 		if err != nil {
 			fmt.Println(err)
-			os.Exit(1)
+			return 1
 		}
 
 		defer f.Close()
-		w = bufio.NewWriter(f)
+		out = newOutput(transcode(teeWriter{f}), outputFormat)
 
 	case "/gsqlnone/":
-		w = bufio.NewWriter(os.Stdout)
+		out = newOutput(transcode(stdout), outputFormat)
 
 	}
+	defer out.Close()
 
 	// open input
 	switch inputFile {
 	case "/gsqlnone/":
+		if singleTransaction {
+			fmt.Println("--single-transaction requires an input file")
+			return 1
+		}
 		// get readline instance
-		r, err = newReadLineBatchReader(conn)
+		if r, err = newReadLineBatchReader(); err == nil {
+			confirm = r.(*readLineBatchReader).confirm
+			ask = r.(*readLineBatchReader).ask
+			keepLast = true
+		}
 	default:
-		r, err = newFileBatchReader(inputFile, w)
+		r, err = newFileBatchReader(inputFile, out.Writer)
 	}
 
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		return 1
 	}
 	defer r.Close()
+	defer stopSpool()
+
+	_, interactive := r.(*readLineBatchReader)
+	runCtx = watchInterrupts(runCtx, interactive)
+
+	if singleTransaction {
+		if _, err = current.conn.ExecContext(context.Background(), "begin tran"); err != nil {
+			if _, ok := err.(tds.SybError); !ok {
+				fmt.Println(err)
+			}
+			return 1
+		}
+	}
 
+	if totalTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, time.Duration(totalTimeout)*time.Second)
+		defer cancel()
+	}
+
+	batchNo := 0
 input:
 	for {
+		if stopped() {
+			if singleTransaction && batchNo > 0 {
+				rollback(current.conn, batchNo, batch)
+			}
+			return 130
+		}
+		if runCtx.Err() != nil {
+			fmt.Fprintf(stdout, "total timeout of %ds reached\n", totalTimeout)
+			return 1
+		}
+
 		batch, err = r.ReadBatch(terminator)
 		if err != nil {
-			if err != io.EOF {
-				fmt.Println(err)
+			if err == io.EOF {
+				break
+			}
+			if fr, ok := r.(*fileBatchReader); ok {
+				batchFile, batchLine = fr.name, fr.line
+			}
+			fmt.Fprintf(stdout, "%s%s\n", location(0), err)
+			// a partly read script is not committed
+			if singleTransaction {
+				rollback(current.conn, batchNo+1, batch)
+				return 1
 			}
 			break
 		}
 
-		// handle cancelation
-		ctx, cancel := context.WithCancel(context.Background())
+		// readline already displayed the input
+		if _, ok := r.(*readLineBatchReader); ok {
+			spool(batch)
+		}
 
-		c := make(chan os.Signal)
-		done := make(chan struct{})
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			select {
-			case <-c:
-				cancel()
-				<-done
-			case <-done:
+		if isCommand(batch) {
+			if err = runCommand(current, batch); err == errQuit {
+				break input
+			} else if err != nil {
+				fmt.Fprintln(stdout, err)
+				// the script is not committed without its commands
+				if singleTransaction {
+					rollback(current.conn, batchNo+1, batch)
+					return exitStatus()
+				}
 			}
-		}()
+			continue input
+		}
 
-		// send query
-		rows, err := conn.QueryContext(ctx, batch)
-		select {
-		case <-done:
-		case done <- struct{}{}:
+		batchNo++
+		batchSeverity = 0
+		// \format applies from the next batch
+		out.format = outputFormat
+		if fr, ok := r.(*fileBatchReader); ok {
+			batchFile, batchLine = fr.name, fr.start
+		}
+
+		if batchCount < 1 {
+			fmt.Fprintf(stdout, "%sinvalid batch count %d, expected at least 1\n", location(0), batchCount)
+			if singleTransaction {
+				rollback(current.conn, batchNo, batch)
+				return 1
+			}
+			continue input
+		}
+
+		// run the batch on every session
+		if onAll {
+			onAll = false
+			failed := false
+			for _, name := range sessionNames() {
+				fmt.Fprintln(out, "---- "+name+" ----")
+				for i := 0; i < batchCount && (i == 0 || err == nil); i++ {
+					err = execParams(sessions[name], out, batch)
+				}
+				if err == nil {
+					sessions[name].track(batch)
+					sessions[name].saveEnv()
+				} else {
+					failed = true
+				}
+			}
+			if singleTransaction && (failed || batchSeverity >= rollbackSeverity) {
+				rollback(current.conn, batchNo, batch)
+				return exitStatus()
+			}
+			continue input
+		}
+
+		s := current
+		for i := 0; i < batchCount && (i == 0 || err == nil); i++ {
+			err = execParams(s, out, batch)
+		}
+
+		if singleTransaction && (err != nil || batchSeverity >= rollbackSeverity) {
+			rollback(s.conn, batchNo, batch)
+			return exitStatus()
+		}
+
+		// only the prompt survives Ctrl-C
+		if !interactive && err == errCancelled {
+			return exitStatus()
 		}
 
 		if err != nil {
-			// SQL errors are printed by the error handler
-			if _, ok := err.(tds.SybError); !ok {
-				fmt.Println(err)
+			// offer to reconnect if the connection was lost
+			if rl, ok := r.(*readLineBatchReader); ok && !s.alive() &&
+				rl.confirm("connection lost. Reconnect?", true) {
+				if err = s.reconnect(); err != nil {
+					fmt.Fprintln(stdout, "failed to reconnect: ", err)
+				} else {
+					fmt.Fprintln(stdout, "reconnected")
+				}
 			}
 			continue input
 		}
 
-		tblfmt.EncodeAll(w, rows, map[string]string{"format": "aligned", "border": "2",
-			"unicode_border_linestyle": "single", "linestyle": "unicode"})
+		s.track(batch)
+		s.saveEnv()
+	}
 
-		rows.Close()
+	if singleTransaction {
+		if _, err = current.conn.ExecContext(context.Background(), "commit tran"); err != nil {
+			if _, ok := err.(tds.SybError); !ok {
+				fmt.Fprintln(stdout, err)
+			}
+			return 1
+		}
 	}
+	return 0
 }
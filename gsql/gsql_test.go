@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+// the lines are added to the batch until the terminator, followed by an optional count
+func TestProcessLine(t *testing.T) {
+	defer setTerminator(terminator)
+	if err := setTerminator(";|^go"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		line     string
+		batch    string
+		expected string
+		found    bool
+		count    int
+	}{
+		{"select 1", "", "select 1", false, 1},
+		{"from t", "select 1", "select 1\nfrom t", false, 1},
+		{"select 1;", "", "select 1", true, 1},
+		{"from t;  ", "select 1", "select 1\nfrom t", true, 1},
+		{"go", "select 1", "select 1", true, 1},
+		{"go 3", "select 1", "select 1", true, 3},
+		{"go 0", "select 1", "select 1", true, 0},
+		{"going", "", "going", false, 1},
+		{" go", "select 1", "select 1\n go", false, 1},
+		{`\format csv`, "", `\format csv`, true, 1},
+		{`  \format csv `, "", `\format csv`, true, 1},
+		{`\format csv`, "select 1", "select 1\n\\format csv", false, 1},
+	} {
+		batchCount = 1
+		batch, found := processLine(terminator, test.line, test.batch)
+		if batch != test.expected || found != test.found || batchCount != test.count {
+			t.Errorf("%q after %q: expected %q (%t, %d), got %q (%t, %d)", test.line, test.batch,
+				test.expected, test.found, test.count, batch, found, batchCount)
+		}
+	}
+}
+
+func TestSetTerminator(t *testing.T) {
+	defer setTerminator(terminator)
+
+	for _, test := range []struct {
+		terminator string
+		valid      bool
+	}{
+		{";", true},
+		{"^go", true},
+		{"^(go|GO)", true},
+		{"[", false},
+		{"(go", false},
+	} {
+		previous := terminator
+		err := setTerminator(test.terminator)
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%t for %s, got %v", test.valid, test.terminator, err)
+		}
+		if err != nil && terminator != previous {
+			t.Errorf("the terminator changed to %s despite the error", terminator)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	// errCancelled is returned by the batches interrupted with Ctrl-C
+	errCancelled = errors.New("cancelled")
+	// errQuit is returned by \q to leave
+	errQuit = errors.New("quit")
+)
+
+// interrupts dispatches the signals to the running batch
+var interrupts struct {
+	sync.Mutex
+	// cancels the running batch, if any
+	cancel    context.CancelFunc
+	cancelled bool
+	// the run was stopped by a signal
+	stopped bool
+}
+
+// watchInterrupts handles the signals for the whole run,
+// and returns the context of the run, cancelled when the run is stopped.
+// Ctrl-C cancels the running batch, the server being sent an attention.
+// At the prompt, readline gets Ctrl-C as a key which clears the input.
+// Otherwise, and on SIGTERM, the run is stopped: the running batch is cancelled
+// and run returns once the server acknowledged the cancel, closing the output.
+// A second signal exits straight away.
+func watchInterrupts(ctx context.Context, interactive bool) context.Context {
+	ctx, stop := context.WithCancel(ctx)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range c {
+			interrupts.Lock()
+			cancel, stopped := interrupts.cancel, interrupts.stopped
+			interrupts.cancelled = cancel != nil
+			if !interactive || sig == syscall.SIGTERM {
+				interrupts.stopped = true
+			}
+			interrupts.Unlock()
+
+			switch {
+			case stopped:
+				os.Exit(130)
+			case interactive && sig == os.Interrupt:
+				if cancel != nil {
+					cancel()
+				}
+			default:
+				stop()
+			}
+		}
+	}()
+	return ctx
+}
+
+// stopped returns true once the run was stopped by a signal
+func stopped() bool {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	return interrupts.stopped
+}
+
+// exitStatus returns the status of a failed run, 130 if it was stopped by a signal
+func exitStatus() int {
+	if stopped() {
+		return 130
+	}
+	return 1
+}
+
+// startBatch registers the cancel function of the running batch.
+// The function returned unregisters it, telling if the batch was interrupted.
+func startBatch(cancel context.CancelFunc) (end func() bool) {
+	interrupts.Lock()
+	interrupts.cancel, interrupts.cancelled = cancel, false
+	interrupts.Unlock()
+
+	return func() bool {
+		interrupts.Lock()
+		defer interrupts.Unlock()
+		interrupts.cancel = nil
+		return interrupts.cancelled
+	}
+}
+
+// quitCommand leaves gsql
+func quitCommand(s *session, args []string) error {
+	return errQuit
+}
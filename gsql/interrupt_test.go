@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// SIGTERM cancels the run and the running batch, without exiting
+func TestStopSignal(t *testing.T) {
+	ctx := watchInterrupts(context.Background(), true)
+	batch, cancel := context.WithCancel(ctx)
+	end := startBatch(cancel)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-batch.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run was not cancelled")
+	}
+
+	if !end() {
+		t.Error("expected the batch to be reported as cancelled")
+	}
+	if !stopped() || exitStatus() != 130 {
+		t.Errorf("expected the run to be stopped with the status 130, got %d", exitStatus())
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/thda/tds"
+	"golang.org/x/text/transform"
+)
+
+// errNoBulk is returned when the file cannot be loaded with the bulk copy protocol
+var errNoBulk = errors.New("bulk copy unavailable")
+
+// identRe matches the plain identifiers, as accepted by the driver's bulk copy.
+// The table and column names are part of the insert statements.
+var identRe = regexp.MustCompile(`^[\pL_#@][\pL\pN_#@$]*$`)
+
+// validTable returns true for the table names in the form [db.[owner].]table
+func validTable(table string) bool {
+	parts := strings.Split(table, ".")
+	if len(parts) > 3 {
+		return false
+	}
+	for i, p := range parts {
+		// the owner can be omitted, as in db..table
+		if p == "" && len(parts) == 3 && i == 1 {
+			continue
+		}
+		if !identRe.MatchString(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// loader imports a flat file in a table
+type loader struct {
+	table     string
+	file      string
+	delimiter string
+	charset   string
+	header    bool
+	columns   string
+	null      string
+	batchSize int
+	errorFile string
+	inserts   bool
+
+	// table columns of each field of the file, empty to skip a field
+	targets []string
+	// rejected records, with the error in the last field
+	errors           *csv.Writer
+	loaded, rejected int
+}
+
+// parseLoadFlags parses the arguments of the load command
+func parseLoadFlags(args []string) (l *loader, err error) {
+	l = &loader{}
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	fs.StringVar(&l.table, "table", "", "table to load, such as db..table")
+	fs.StringVar(&l.file, "file", "", "file to load")
+	fs.StringVar(&l.delimiter, "delimiter", ",", "field delimiter, such as ; or \\t")
+	fs.StringVar(&l.charset, "encoding", "", "character set of the file, if not utf8. Sybase or IANA name")
+	fs.BoolVar(&l.header, "header", true, "the first record holds the column names")
+	fs.StringVar(&l.columns, "columns", "", "comma separated table columns of the fields, - to skip a field. Defaults to the header")
+	fs.StringVar(&l.null, "null", "", "value of the fields loaded as null")
+	fs.IntVar(&l.batchSize, "batch-size", 1000, "number of rows committed at once")
+	fs.StringVar(&l.errorFile, "errors", "", "file receiving the rejected records. Without it, the load stops at the first error")
+	fs.BoolVar(&l.inserts, "inserts", false, "load with insert statements rather than the bulk copy protocol")
+	if err = fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case l.table == "" || l.file == "":
+		return nil, errors.New("load requires --table and --file")
+	case !validTable(l.table):
+		return nil, fmt.Errorf("invalid table name '%s'", l.table)
+	case utf8.RuneCountInString(unescape.Replace(l.delimiter)) != 1:
+		return nil, errors.New("the delimiter must be a single character")
+	case l.batchSize < 1:
+		return nil, errors.New("the batch size must be positive")
+	}
+	return l, nil
+}
+
+// insertStatement returns the insert of a record, the values being placeholders
+func (l *loader) insertStatement() (string, error) {
+	var cols []string
+	for _, name := range l.targets {
+		if name != "" {
+			cols = append(cols, name)
+		}
+	}
+	if len(cols) == 0 {
+		return "", errors.New("no column to load")
+	}
+	return fmt.Sprintf("insert into %s (%s) values (%s)", l.table, strings.Join(cols, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")), nil
+}
+
+// parseTargets sets the table columns of the fields, from --columns
+// or from the header, the first record of the file
+func (l *loader) parseTargets(first []string) error {
+	switch {
+	case l.columns != "":
+		l.targets = strings.Split(l.columns, ",")
+	case l.header:
+		l.targets = first
+	default:
+		return errors.New("--columns is required without header")
+	}
+	for i := range l.targets {
+		if l.targets[i] = strings.TrimSpace(l.targets[i]); l.targets[i] == "-" {
+			l.targets[i] = ""
+		}
+		if l.targets[i] != "" && !identRe.MatchString(l.targets[i]) {
+			return fmt.Errorf("%s: invalid column name '%s'", l.file, l.targets[i])
+		}
+	}
+	return nil
+}
+
+// values returns the values of the loaded fields of a record
+func (l *loader) values(record []string) ([]interface{}, error) {
+	if len(record) != len(l.targets) {
+		return nil, fmt.Errorf("%d fields, expected %d", len(record), len(l.targets))
+	}
+	var values []interface{}
+	for i, field := range record {
+		if l.targets[i] == "" {
+			continue
+		}
+		if field == l.null {
+			values = append(values, nil)
+		} else {
+			values = append(values, field)
+		}
+	}
+	return values, nil
+}
+
+// reject writes a record to the error file, or fails without one
+func (l *loader) reject(n int, record []string, err error) error {
+	l.rejected++
+	if l.errors == nil {
+		return fmt.Errorf("%s: record %d: %s", l.file, n, strings.TrimSpace(err.Error()))
+	}
+	return l.errors.Write(append(record, strings.TrimSpace(err.Error())))
+}
+
+// run loads the file, committing every batch-size rows.
+// The rows are bulk copied when the table allows it and the file has all its columns,
+// and inserted otherwise.
+func (l *loader) run(ctx context.Context, s *session) (err error) {
+	f, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var in io.Reader = f
+	if l.charset != "" {
+		e, err := lookupEncoding(l.charset)
+		if err != nil {
+			return err
+		}
+		in = transform.NewReader(f, e.NewDecoder())
+	}
+	r := csv.NewReader(in)
+	r.Comma, _ = utf8.DecodeRuneInString(unescape.Replace(l.delimiter))
+	r.FieldsPerRecord = -1
+
+	if l.errorFile != "" {
+		ef, err := os.Create(l.errorFile)
+		if err != nil {
+			return err
+		}
+		defer ef.Close()
+		l.errors = csv.NewWriter(ef)
+		l.errors.Comma = r.Comma
+		defer l.errors.Flush()
+	}
+
+	first, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %s", l.file, err)
+	}
+	if err = l.parseTargets(first); err != nil {
+		return err
+	}
+
+	// the header is loaded with the data when the columns are given
+	next := r.Read
+	if !l.header {
+		next = func() ([]string, error) {
+			next = r.Read
+			return first, nil
+		}
+	}
+	// next changes after the first call
+	read := func() ([]string, error) { return next() }
+
+	if !l.inserts {
+		if err = l.bulkRows(ctx, s, read); err != errNoBulk {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "bulk copy unavailable for this table or file, loading with inserts")
+	}
+	return l.insertRows(ctx, s, read)
+}
+
+// insertRows inserts the records, in a transaction per batch
+func (l *loader) insertRows(ctx context.Context, s *session, read func() ([]string, error)) (err error) {
+	insert, err := l.insertStatement()
+	if err != nil {
+		return err
+	}
+	stmt, err := s.conn.PrepareContext(ctx, insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// records sent and rows inserted in the current transaction
+	n, inBatch, batchLoaded := 0, 0, 0
+	commit := func() error {
+		if inBatch == 0 {
+			return nil
+		}
+		inBatch, batchLoaded = 0, 0
+		_, err := s.conn.ExecContext(context.Background(), "commit tran")
+		return err
+	}
+	defer func() {
+		if err != nil && inBatch > 0 {
+			s.conn.ExecContext(context.Background(), "rollback tran")
+		}
+	}()
+
+	record, err := read()
+	for ; err == nil; record, err = read() {
+		n++
+		values, verr := l.values(record)
+		if verr != nil {
+			if err = l.reject(n, record, verr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if inBatch == 0 {
+			if _, err = s.conn.ExecContext(context.Background(), "begin tran"); err != nil {
+				return err
+			}
+		}
+		inBatch++
+		if _, err = stmt.ExecContext(ctx, values...); err == nil {
+			l.loaded++
+			batchLoaded++
+		} else if !s.inTransaction() {
+			// the server rolled back the whole batch
+			l.loaded -= batchLoaded
+			inBatch, batchLoaded = 0, 0
+			return fmt.Errorf("%s: record %d: the batch was rolled back: %s", l.file, n,
+				strings.TrimSpace(err.Error()))
+		} else if err = l.reject(n, record, err); err != nil {
+			return err
+		}
+
+		if inBatch >= l.batchSize {
+			if err = commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if err != io.EOF {
+		return fmt.Errorf("%s: record %d: %s", l.file, n+1, err)
+	}
+	err = nil
+	return commit()
+}
+
+// bulkRows copies the records with the bulk copy protocol, committing every batch-size rows.
+// Returns errNoBulk if the table does not support it, or if the file does not have all its columns.
+func (l *loader) bulkRows(ctx context.Context, s *session, read func() ([]string, error)) error {
+	return s.conn.Raw(func(dc interface{}) error {
+		c, ok := dc.(*tds.Conn)
+		if !ok {
+			return errNoBulk
+		}
+		// cancelling discards the batch in progress
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		bk, err := c.NewBulk(ctx, l.table, tds.BulkOptions{})
+		if err == tds.ErrBulkUnsupported {
+			return errNoBulk
+		} else if err != nil {
+			return err
+		}
+
+		// field of each column of the table
+		fields, targets := make([]int, len(bk.Columns())), 0
+		for _, name := range l.targets {
+			if name != "" {
+				targets++
+			}
+		}
+		for i, name := range bk.Columns() {
+			fields[i] = -1
+			for j, target := range l.targets {
+				if target == name {
+					fields[i] = j
+				}
+			}
+			if fields[i] < 0 {
+				return errNoBulk
+			}
+		}
+		if targets != len(fields) {
+			return errNoBulk
+		}
+
+		// the rows of the batch in progress are discarded on failure
+		abort := func(err error) error {
+			cancel()
+			bk.Done()
+			return err
+		}
+
+		n, inBatch := 0, 0
+		record, err := read()
+		for ; err == nil; record, err = read() {
+			n++
+			if len(record) != len(l.targets) {
+				if err = l.reject(n, record, fmt.Errorf("%d fields, expected %d", len(record), len(l.targets))); err != nil {
+					return abort(err)
+				}
+				continue
+			}
+			values := make([]driver.Value, len(fields))
+			for i, f := range fields {
+				if record[f] != l.null {
+					values[i] = record[f]
+				}
+			}
+			if err = bk.AddRow(values); err != nil {
+				if _, ok := err.(tds.BulkRowError); !ok {
+					return abort(fmt.Errorf("%s: record %d: %s", l.file, n, strings.TrimSpace(err.Error())))
+				}
+				if err = l.reject(n, record, err); err != nil {
+					return abort(err)
+				}
+				continue
+			}
+
+			if inBatch++; inBatch >= l.batchSize {
+				copied, err := bk.Flush()
+				if err != nil {
+					return fmt.Errorf("%s: record %d: the batch was rolled back: %s", l.file, n,
+						strings.TrimSpace(err.Error()))
+				}
+				l.loaded, inBatch = l.loaded+int(copied), 0
+			}
+		}
+		if err != io.EOF {
+			return abort(fmt.Errorf("%s: record %d: %s", l.file, n+1, err))
+		}
+		copied, err := bk.Done()
+		if err != nil {
+			return fmt.Errorf("%s: the last batch was rolled back: %s", l.file, strings.TrimSpace(err.Error()))
+		}
+		l.loaded = int(copied)
+		return nil
+	})
+}
+
+// loadCommand runs the load subcommand on the session
+func loadCommand(s *session, args []string) error {
+	l, err := parseLoadFlags(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	end := startBatch(cancel)
+	err = l.run(ctx, s)
+	if end() {
+		err = errCancelled
+	}
+	fmt.Fprintf(os.Stderr, "%d rows loaded, %d rejected\n", l.loaded, l.rejected)
+	return err
+}
+
+// bcpCommand bulk copies a file into a table, with the options of the load subcommand
+func bcpCommand(s *session, args []string) error {
+	if len(args) < 2 {
+		return errors.New(`usage: \bcp table file [load options]`)
+	}
+	return loadCommand(s, append([]string{"--table", args[0], "--file", args[1]}, args[2:]...))
+}
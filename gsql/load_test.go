@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// the table is part of the insert statements, and must be a plain name
+func TestLoadTable(t *testing.T) {
+	for _, test := range []struct {
+		table string
+		valid bool
+	}{
+		{"mytable", true},
+		{"dbo.mytable", true},
+		{"mydb..mytable", true},
+		{"mydb.dbo.#temp", true},
+		{"", false},
+		{"a.b.c.d", false},
+		{"t (a) select 1; drop table x --", false},
+		{"my table", false},
+		{"[mytable]", false},
+	} {
+		_, err := parseLoadFlags([]string{"--table", test.table, "--file", "data.csv"})
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%t for '%s', got %v", test.valid, test.table, err)
+		}
+	}
+}
+
+// the column names of the header or of --columns must be plain names
+func TestLoadColumns(t *testing.T) {
+	for _, test := range []struct {
+		header   string
+		columns  string
+		expected string
+		valid    bool
+	}{
+		{"id,name", "", "id,name", true},
+		{"id, name ,-", "", "id,name,", true},
+		{"x", "id,-,name", "id,,name", true},
+		{"id,name) select password from syslogins --", "", "", false},
+		{"id,na me", "", "", false},
+		{"x", "id,[name]", "", false},
+	} {
+		l := &loader{header: true, columns: test.columns}
+		err := l.parseTargets(strings.Split(test.header, ","))
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%t for '%s' '%s', got %v", test.valid, test.header, test.columns, err)
+			continue
+		}
+		if err == nil && strings.Join(l.targets, ",") != test.expected {
+			t.Errorf("expected the columns %s, got %v", test.expected, l.targets)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thda/tds"
+)
+
+// showplan output collected during the batch, displayed when the batch completes
+var (
+	showplan bool
+	plan     strings.Builder
+)
+
+// position of the running batch in the input file, to locate the errors
+var (
+	batchFile string
+	batchLine int
+)
+
+// location returns the "file:line: " prefix of an error raised at the given line
+// of the batch, or at its first line if unknown. Empty when not reading a file.
+func location(line int) string {
+	if batchFile == "" {
+		return ""
+	}
+	if line < 1 {
+		line = 1
+	}
+	return fmt.Sprintf("%s:%d: ", batchFile, batchLine+line-1)
+}
+
+// muted drops the messages of the queries run by gsql itself,
+// such as the prompt's, which would otherwise show in the plan
+// and the statistics of the next batch
+var muted bool
+
+// mute runs fn with the messages of the server dropped
+func mute(fn func()) {
+	muted = true
+	defer func() { muted = false }()
+	fn()
+}
+
+// isPlanMessage returns true for the showplan messages
+func isPlanMessage(m tds.SybError) bool {
+	return m.Severity == 10 &&
+		((m.MsgNumber >= 3612 && m.MsgNumber <= 3615) ||
+			(m.MsgNumber >= 6201 && m.MsgNumber <= 6299) ||
+			(m.MsgNumber >= 10201 && m.MsgNumber <= 10299))
+}
+
+// handleMessage prints the messages sent by the server,
+// and returns true if the message is an error.
+func handleMessage(m tds.SybError) bool {
+	if muted {
+		return m.Severity > 10
+	}
+	currentProgress.clear()
+
+	if int(m.Severity) > batchSeverity {
+		batchSeverity = int(m.Severity)
+	}
+
+	// statistics are summarized at the end of the batch
+	if showStats && stats.add(m) {
+		return false
+	}
+
+	// hide the chatter, showplan being explicitly requested
+	if int(m.Severity) < minSeverity && !isPlanMessage(m) {
+		return m.Severity > 10
+	}
+
+	if isPlanMessage(m) {
+		if showplan {
+			plan.WriteString(m.Message)
+		} else {
+			fmt.Fprint(stdout, m.Message)
+		}
+	} else if m.Severity == 10 && !quiet {
+		fmt.Fprintln(stdout, strings.TrimRight(m.Message, "\n"))
+	}
+
+	if m.Severity > 10 {
+		// the line of an error raised in a procedure is relative to the procedure
+		line := int(m.LineNumber)
+		if m.Procedure != "" {
+			line = 0
+		}
+		fmt.Fprintln(stdout, colorize(severityColor(m.Severity),
+			strings.TrimRight(location(line)+m.Error(), "\n")))
+	}
+	return m.Severity > 10
+}
+
+// severityColor returns the color of an error: red for the user errors,
+// bold red from severity 17 for the resource and fatal errors.
+func severityColor(severity int8) string {
+	if severity >= 17 {
+		return colorBoldRed
+	}
+	return colorRed
+}
+
+// printPlan displays the showplan output collected during the batch
+func printPlan() {
+	if plan.Len() == 0 {
+		return
+	}
+	fmt.Fprintln(stdout, colorize(colorCyan, "---- showplan ----"))
+	fmt.Fprint(stdout, colorize(colorCyan, strings.TrimRight(plan.String(), "\n")+"\n"))
+	fmt.Fprintln(stdout, colorize(colorCyan, "---- end of showplan ----"))
+	plan.Reset()
+}
+
+// showplanCommand toggles the showplan session option
+func showplanCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "showplan is", onOff(showplan))
+		return nil
+	}
+	on, err := parseOnOff(args[0])
+	if err != nil {
+		return err
+	}
+	if err = s.set("set showplan " + onOff(on)); err != nil {
+		return err
+	}
+	showplan = on
+	return nil
+}
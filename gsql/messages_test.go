@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/thda/tds"
+)
+
+// the plan and the statistics of the queries run by gsql are dropped
+func TestMute(t *testing.T) {
+	defer func(plans, st bool, w io.Writer) {
+		showplan, showStats, stdout = plans, st, w
+	}(showplan, showStats, stdout)
+
+	var b bytes.Buffer
+	stdout, showplan, showStats = &b, true, true
+	msgs := []tds.SybError{
+		{MsgNumber: 3612, Severity: 10, Message: "QUERY PLAN FOR STATEMENT 1\n"},
+		{MsgNumber: 3614, Severity: 10, Message: "Execution Time 2.\n"},
+	}
+
+	mute(func() {
+		for _, m := range msgs {
+			handleMessage(m)
+		}
+	})
+	if plan.Len() != 0 || stats.received || b.Len() != 0 {
+		t.Fatalf("expected the messages to be dropped, got the plan %q and the output %q", plan.String(), b.String())
+	}
+
+	for _, m := range msgs {
+		handleMessage(m)
+	}
+	if plan.Len() == 0 || !stats.received {
+		t.Error("expected the messages of the batch to be kept")
+	}
+	plan.Reset()
+	stats = batchStats{tables: make(map[string]*tableIO)}
+}
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	runewidth "github.com/mattn/go-runewidth"
+	"github.com/thda/tds"
+	"github.com/xo/tblfmt"
+	htmlcharset "golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+// resultSet wraps the rows returned by a batch
+// to limit the number of rows fetched per result set.
+type resultSet struct {
+	*sql.Rows
+	maxRows int
+	// rows fetched in the current result set
+	count int
+	// rows fetched in all the result sets
+	total int
+	// rows were skipped in the current result set
+	truncated bool
+	// cancels the batch once the row limit is reached,
+	// so that the remaining rows are not fetched
+	cancel   func()
+	limited  bool
+	progress *progress
+	// copy of the current result set, kept for \copy-last
+	capture *capturedResult
+	// in streaming mode, flushes the output before waiting for the rows
+	flush   func() error
+	flushed time.Time
+}
+
+// delay between the flushes of the output in streaming mode
+const streamFlushDelay = 100 * time.Millisecond
+
+// Next fetches the next row. Once the row limit is reached,
+// the batch is cancelled if more rows are pending: the server
+// is sent an attention and the remaining rows are not fetched.
+func (rs *resultSet) Next() bool {
+	if rs.flush != nil && (rs.count == 1 || time.Since(rs.flushed) >= streamFlushDelay) {
+		rs.flush()
+		rs.flushed = time.Now()
+	}
+	if rs.maxRows > 0 && rs.count >= rs.maxRows {
+		if !rs.limited && rs.Rows.Next() {
+			rs.truncated, rs.limited = true, true
+			rs.cancel()
+		}
+		return false
+	}
+	if !rs.Rows.Next() {
+		return false
+	}
+	rs.count++
+	rs.total++
+	rs.progress.row()
+	return true
+}
+
+// Scan copies the row to the capture, if any
+func (rs *resultSet) Scan(dest ...interface{}) error {
+	if err := rs.Rows.Scan(dest...); err != nil {
+		return err
+	}
+	if rs.capture != nil {
+		rs.capture.add(dest)
+	}
+	return nil
+}
+
+// NextResultSet moves to the next result set and resets the row count.
+// None is left once the batch was cancelled on the row limit.
+func (rs *resultSet) NextResultSet() bool {
+	rs.count, rs.truncated = 0, false
+	return !rs.limited && rs.Rows.NextResultSet()
+}
+
+// Err ignores the cancelation of the batch on the row limit
+func (rs *resultSet) Err() error {
+	if rs.limited {
+		return nil
+	}
+	return rs.Rows.Err()
+}
+
+// gzipFile compresses the data written to a file
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Close flushes the compressed stream and closes the file
+func (g gzipFile) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openOutput creates or truncates the output file.
+// The output is compressed when the file name ends with .gz or with --compress
+func openOutput(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !compress && !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	return gzipFile{Writer: gzip.NewWriter(f), f: f}, nil
+}
+
+// lookupEncoding returns the encoding of a character set, by sybase or IANA name
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	if e, err := tds.LookupEncoding(name); err == nil {
+		return e, nil
+	}
+	if e, _ := htmlcharset.Lookup(name); e != nil {
+		return e, nil
+	}
+	if e, _ := ianaindex.IANA.Encoding(name); e != nil {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown character set '%s'", name)
+}
+
+// transcode converts the results written to w to the output character set.
+// Characters missing from the character set are replaced.
+func transcode(w io.Writer) io.Writer {
+	if outputEncoding == nil || outputFormat == "xlsx" {
+		return w
+	}
+	return transform.NewWriter(w, encoding.ReplaceUnsupported(outputEncoding.NewEncoder()))
+}
+
+// output formats
+var outputFormats = map[string]bool{"table": true, "raw": true, "csv": true, "json": true,
+	"vertical": true, "xlsx": true, "template": true}
+
+// formatsByExtension gives the output format of the output files' extensions
+var formatsByExtension = map[string]string{".csv": "csv", ".json": "json", ".ndjson": "json", ".xlsx": "xlsx",
+	".txt": "raw"}
+
+// autoFormat switches to a machine friendly output when the output is not a terminal,
+// unless --pretty is given. The output format is guessed from the extension
+// of the output file, csv by default. Colors and progress are turned off.
+// The flags given explicitly are left alone.
+func autoFormat() {
+	if pretty || (outputFile == "/gsqlnone/" && readline.IsTerminal(int(os.Stdout.Fd()))) {
+		return
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["m"] && !set["F"] {
+		outputFormat = "csv"
+		if format, ok := formatsByExtension[filepath.Ext(strings.TrimSuffix(outputFile, ".gz"))]; ok {
+			outputFormat = format
+		}
+	}
+	if !set["color"] {
+		useColor = false
+	}
+	if !set["progress"] {
+		showProgress = false
+	}
+}
+
+// output writes the results in the requested format
+type output struct {
+	*bufio.Writer
+	format string
+	// xlsx output, created with the first result set
+	workbook *workbook
+	// result sets written, numbered in the summary
+	resultSets int
+	// rows fetched by the last batch
+	rows int
+}
+
+func newOutput(w io.Writer, format string) *output {
+	return &output{Writer: bufio.NewWriter(w), format: format}
+}
+
+// Close terminates the output
+func (o *output) Close() error {
+	if o.workbook != nil {
+		if err := o.workbook.Close(); err != nil {
+			return err
+		}
+	}
+	return o.Flush()
+}
+
+// render writes all the result sets returned by a batch.
+// cancel cancels the batch, once the row limit is reached.
+func (o *output) render(rows *sql.Rows, p *progress, cancel func()) error {
+	rs := &resultSet{Rows: rows, maxRows: maxRows, progress: p, cancel: cancel}
+	defer func() { o.rows = rs.total }()
+	if stream {
+		rs.flush = o.Flush
+	}
+	var w io.Writer = o.Writer
+	if p != nil {
+		w = progressWriter{Writer: w, p: p}
+	}
+
+	for {
+		encode := o.encode
+		if splitPrefix != "" {
+			encode = o.split
+		}
+
+		rs.capture = nil
+		if cols, err := rs.Columns(); keepLast && !stream && err == nil && len(cols) > 0 {
+			rs.capture = &capturedResult{columns: cols}
+		}
+
+		// statements without result set (insert, update...) have no columns
+		if err := encode(w, rs); err != nil && err != tblfmt.ErrResultSetHasNoColumns {
+			return err
+		}
+		if rs.capture != nil {
+			lastResult = rs.capture
+		}
+
+		if rs.truncated {
+			// keep the notice out of machine readable formats
+			notice := w
+			if o.format != "table" || quiet || splitPrefix != "" {
+				notice = os.Stderr
+			}
+			fmt.Fprintf(notice, "(output truncated to %d rows, the rest of the batch was cancelled)\n", rs.maxRows)
+		}
+
+		if !rs.NextResultSet() {
+			break
+		}
+		if o.format == "table" && !quiet && splitPrefix == "" && !summary {
+			fmt.Fprintln(w)
+		}
+	}
+	return rs.Err()
+}
+
+// file extension of each output format
+var extensions = map[string]string{"table": "txt", "raw": "txt", "csv": "csv", "json": "json",
+	"vertical": "txt", "xlsx": "xlsx", "template": "txt"}
+
+// number of files written with --split-output
+var splitFiles int
+
+// split writes the current result set to its own numbered file
+func (o *output) split(w io.Writer, rs *resultSet) error {
+	if cols, err := rs.Columns(); err != nil || len(cols) == 0 {
+		return err
+	}
+
+	splitFiles++
+	name := fmt.Sprintf("%s_%03d.%s", splitPrefix, splitFiles, extensions[o.format])
+	if compress {
+		name += ".gz"
+	}
+	f, err := openOutput(name)
+	if err != nil {
+		return err
+	}
+
+	so := newOutput(transcode(f), o.format)
+	err = so.encode(so.Writer, rs)
+	if cerr := so.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// encode writes the current result set
+func (o *output) encode(w io.Writer, rs *resultSet) error {
+	if summary {
+		return o.encodeSummary(w, rs)
+	}
+
+	// rows are written as they arrive, without alignment
+	if stream {
+		switch o.format {
+		case "table", "raw":
+			return encodeRaw(w, rs)
+		}
+	}
+
+	switch o.format {
+	case "xlsx":
+		if o.workbook == nil {
+			o.workbook = newWorkbook(o.Writer)
+		}
+		return o.workbook.addSheet(rs)
+	case "raw":
+		return encodeRaw(w, rs)
+	case "csv":
+		return encodeCSV(w, rs)
+	case "json":
+		return encodeJSON(w, rs)
+	case "vertical":
+		return encodeVertical(w, rs)
+	case "template":
+		return encodeTemplate(w, rs)
+	}
+
+	if noHeader {
+		return encodePlain(w, rs)
+	}
+
+	var set tblfmt.ResultSet = rs
+	if browsing() {
+		var err error
+		if set, err = o.browseResult(rs); set == nil || err != nil {
+			return err
+		}
+	}
+
+	builder, opts := tblfmt.FromMap(themes[strings.ToLower(string(theme))])
+	opts = append(opts, tblfmt.WithCount(pageSize), tblfmt.WithFormatter(&widthFormatter{Formatter: valueFormatter{
+		tblfmt.NewEscapeFormatter(tblfmt.WithTimeFormat(dateFormat))}}))
+	if nullValue != "" {
+		opts = append(opts, tblfmt.WithEmpty(nullValue))
+	}
+	enc, err := builder(set, opts...)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(w)
+}
+
+// table display themes, by lower case name
+var themes = map[string]map[string]string{
+	"asciicompact": {"format": "aligned", "border": "2", "linestyle": "ascii"},
+	"utfcompact": {"format": "aligned", "border": "2",
+		"unicode_border_linestyle": "single", "linestyle": "unicode"},
+	"utfdouble": {"format": "aligned", "border": "2",
+		"unicode_border_linestyle": "double", "linestyle": "unicode"},
+}
+
+// themeName is the name of a table display theme
+type themeName string
+
+func (t themeName) String() string {
+	return string(t)
+}
+
+// Set checks that the theme exists
+func (t *themeName) Set(s string) error {
+	if _, ok := themes[strings.ToLower(s)]; !ok {
+		return fmt.Errorf("unknown theme '%s'", s)
+	}
+	*t = themeName(s)
+	return nil
+}
+
+// formatValue returns the text representation of a value
+// for the formats not handled by tblfmt
+func formatValue(v interface{}) string {
+	if s, ok := numFmt.format(v); ok {
+		return s
+	}
+	switch v := v.(type) {
+	case nil:
+		return nullValue
+	case string:
+		return v
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case time.Time:
+		return v.Format(dateFormat)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// eachRow calls fn with the values of each row of the current result set
+func eachRow(rs *resultSet, fn func(row []interface{}) error) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	ptrs := make([]interface{}, len(cols))
+	row := make([]interface{}, len(cols))
+	for i := range ptrs {
+		ptrs[i] = new(interface{})
+	}
+
+	for rs.Next() {
+		if err = rs.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, p := range ptrs {
+			row[i] = *(p.(*interface{}))
+		}
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+	return rs.Err()
+}
+
+// encodeCSV writes the current result set as CSV
+func encodeCSV(w io.Writer, rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	if csvHeader && !noHeader {
+		if err = writeCSV(w, cols); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(cols))
+	return eachRow(rs, func(row []interface{}) error {
+		for i, v := range row {
+			record[i] = formatValue(v)
+		}
+		return writeCSV(w, record)
+	})
+}
+
+// writeCSV writes a CSV record, quoting the fields as requested
+func writeCSV(w io.Writer, record []string) error {
+	var b strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			b.WriteString(csvDelimiter)
+		}
+		if !csvQuoteAll && !strings.ContainsAny(field, csvDelimiter+"\r\n"+csvQuote) &&
+			strings.TrimSpace(field) == field {
+			b.WriteString(field)
+			continue
+		}
+		b.WriteString(csvQuote + strings.Replace(field, csvQuote, csvQuote+csvQuote, -1) + csvQuote)
+	}
+	if csvCRLF {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// checkCSVDelimiter checks that the CSV delimiter is a single character,
+// distinct from the quote
+func checkCSVDelimiter(d string) error {
+	if utf8.RuneCountInString(d) != 1 || d == csvQuote || d == "\r" || d == "\n" {
+		return fmt.Errorf("invalid CSV delimiter '%s', expected a single character other than the quote", d)
+	}
+	return nil
+}
+
+// canonicalValue returns a representation of a value
+// which does not depend on the display settings
+func canonicalValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return hex.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// encodeSummary writes the row count, the size and a checksum of the result set.
+// The checksum is the sum of the hashes of the rows, so that it does not depend
+// on the order of the rows.
+func (o *output) encodeSummary(w io.Writer, rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	var rows, size int
+	var sum uint64
+	h := fnv.New64a()
+	err = eachRow(rs, func(row []interface{}) error {
+		h.Reset()
+		for _, v := range row {
+			s := canonicalValue(v)
+			size += len(s)
+			io.WriteString(h, s)
+			h.Write([]byte{0})
+		}
+		sum += h.Sum64()
+		rows++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	o.resultSets++
+	_, err = fmt.Fprintf(w, "result set %d: %d columns, %d rows, %d bytes, checksum %016x\n",
+		o.resultSets, len(cols), rows, size, sum)
+	return err
+}
+
+// encodeRaw writes the values separated by the column separator, without padding,
+// the lines being wrapped at the line width if set, like isql does.
+func encodeRaw(w io.Writer, rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	writeLine := func(values []string) error {
+		line := []rune(strings.Join(values, columnSeparator))
+		for width > 0 && len(line) > width {
+			if _, err := io.WriteString(w, string(line[:width])+"\n"); err != nil {
+				return err
+			}
+			line = line[width:]
+		}
+		_, err := io.WriteString(w, string(line)+"\n")
+		return err
+	}
+
+	if !noHeader {
+		if err = writeLine(cols); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(cols))
+	return eachRow(rs, func(row []interface{}) error {
+		for i, v := range row {
+			record[i] = formatValue(v)
+		}
+		return writeLine(record)
+	})
+}
+
+// typedJSONValue returns the value written in JSON, keeping its type:
+// numbers are written as numbers, dates in RFC3339 and binaries in base64.
+// NaN and the infinities, which JSON lacks, are written as strings.
+func typedJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, int64, uint64, []byte:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return v
+	case tds.Num:
+		s := v.String()
+		// the leading zero is required by JSON
+		if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "-.") {
+			s = strings.Replace(s, ".", "0.", 1)
+		}
+		return json.Number(s)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// encodeJSON writes the current result set as newline delimited JSON, one object per row,
+// as the rows arrive. The objects keep the order of the columns.
+func encodeJSON(w io.Writer, rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	names := make([][]byte, len(cols))
+	for i, name := range cols {
+		if names[i], err = json.Marshal(name); err != nil {
+			return err
+		}
+	}
+
+	return eachRow(rs, func(row []interface{}) error {
+		b := []byte{'{'}
+		for i, v := range row {
+			value, err := json.Marshal(typedJSONValue(v))
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(append(append(b, names[i]...), ':'), value...)
+		}
+		_, err := w.Write(append(b, '}', '\n'))
+		return err
+	})
+}
+
+// encodeVertical writes each row as a record, one line per column,
+// for the result sets too wide to be read as a table
+func encodeVertical(w io.Writer, rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	nameWidth := 0
+	for _, name := range cols {
+		if cw := runewidth.StringWidth(name); cw > nameWidth {
+			nameWidth = cw
+		}
+	}
+	// continuation lines of the multi-line values are aligned on the values
+	indent := "\n" + strings.Repeat(" ", nameWidth) + " | "
+
+	n := 0
+	return eachRow(rs, func(row []interface{}) error {
+		n++
+		var b strings.Builder
+		fmt.Fprintf(&b, "-[ RECORD %d ]%s\n", n, strings.Repeat("-", nameWidth))
+		for i, v := range row {
+			value := strings.Replace(formatValue(v), "\n", indent, -1)
+			fmt.Fprintf(&b, "%s | %s\n", runewidth.FillRight(cols[i], nameWidth), value)
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	})
+}
+
+// encodePlain writes the current result set aligned in columns,
+// without borders, header or row count
+func encodePlain(w io.Writer, rs *resultSet) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	err := eachRow(rs, func(row []interface{}) error {
+		for i, v := range row {
+			if i > 0 {
+				tw.Write([]byte{'\t'})
+			}
+			tw.Write([]byte(formatValue(v)))
+		}
+		_, err := tw.Write([]byte{'\n'})
+		return err
+	})
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// numFormat describes how numbers are displayed.
+// Set from a pattern such as #,##0.00, where a comma enables
+// the thousands separator and the digits after the dot give the decimal places.
+type numFormat struct {
+	pattern  string
+	grouping bool
+	decimals int
+}
+
+func (n *numFormat) String() string {
+	return n.pattern
+}
+
+func (n *numFormat) Set(pattern string) error {
+	if strings.Trim(pattern, "#0,.") != "" || strings.Count(pattern, ".") > 1 {
+		return fmt.Errorf("invalid number format '%s', expected a pattern like #,##0.00", pattern)
+	}
+	n.pattern, n.decimals = pattern, 0
+	n.grouping = strings.Contains(pattern, ",")
+	if i := strings.Index(pattern, "."); i >= 0 {
+		n.decimals = len(pattern) - i - 1
+	}
+	return nil
+}
+
+// format returns the formatted number, or false if v is not a number
+func (n *numFormat) format(v interface{}) (string, bool) {
+	if n.pattern == "" {
+		return "", false
+	}
+
+	var s string
+	switch v := v.(type) {
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case uint64:
+		s = strconv.FormatUint(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', n.decimals, 64)
+	case tds.Num:
+		r := v.Rat()
+		s = r.FloatString(n.decimals)
+	default:
+		return "", false
+	}
+
+	if !n.grouping {
+		return s, true
+	}
+
+	// add the thousands separators to the integer part
+	sign, frac := "", ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if i := strings.Index(s, "."); i >= 0 {
+		s, frac = s[:i], s[i:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s + frac, true
+}
+
+// valueFormatter formats the numbers according to --numfmt
+// before handing them to the underlying formatter.
+type valueFormatter struct {
+	tblfmt.Formatter
+}
+
+func (f valueFormatter) Format(vals []interface{}) ([]*tblfmt.Value, error) {
+	var numbers []int
+	for i, val := range vals {
+		v := val.(*interface{})
+		if s, ok := numFmt.format(*v); ok {
+			*v = s
+			numbers = append(numbers, i)
+		}
+	}
+
+	res, err := f.Formatter.Format(vals)
+	if err != nil {
+		return res, err
+	}
+	for _, i := range numbers {
+		if res[i] != nil {
+			res[i].Align = tblfmt.AlignRight
+		}
+	}
+	return res, nil
+}
+
+// minimum width of a column when its width is derived from the line width
+const minColWidth = 8
+
+// columnWidths maps column names to their maximum display width.
+// Set from a comma separated list of name=width pairs.
+type columnWidths map[string]int
+
+func (c columnWidths) String() string {
+	pairs := make([]string, 0, len(c))
+	for name, width := range c {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", name, width))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (c columnWidths) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid column width '%s', expected name=width", pair)
+		}
+		width, err := strconv.Atoi(pair[i+1:])
+		if err != nil || width < 0 {
+			return fmt.Errorf("invalid width for column %s", pair[:i])
+		}
+		c[strings.ToLower(strings.TrimSpace(pair[:i]))] = width
+	}
+	return nil
+}
+
+// widthFormatter limits the display width of the values,
+// either by truncating them or by wrapping them on several lines.
+type widthFormatter struct {
+	tblfmt.Formatter
+	// maximum width per column, zero for unlimited
+	widths []int
+}
+
+// Header computes the maximum width of each column.
+//
+// Per column widths take precedence over --max-col-width,
+// which takes precedence over a fair share of the line width.
+func (f *widthFormatter) Header(headers []string) ([]*tblfmt.Value, error) {
+	max := maxColWidth
+	if max == 0 && width > 0 {
+		// borders take 3 characters per column
+		if max = (width - 3*len(headers) - 1) / len(headers); max < minColWidth {
+			max = minColWidth
+		}
+	}
+
+	f.widths = make([]int, len(headers))
+	for i, header := range headers {
+		f.widths[i] = max
+		if w, ok := colWidths[strings.ToLower(header)]; ok {
+			f.widths[i] = w
+		}
+	}
+	return f.Formatter.Header(headers)
+}
+
+// Format truncates or wraps the values wider than their column's limit
+func (f *widthFormatter) Format(vals []interface{}) ([]*tblfmt.Value, error) {
+	res, err := f.Formatter.Format(vals)
+	if err != nil {
+		return res, err
+	}
+
+	for i, v := range res {
+		if v == nil || i >= len(f.widths) || f.widths[i] == 0 ||
+			v.MaxWidth(0, 8) <= f.widths[i] {
+			continue
+		}
+
+		var s string
+		if wrap {
+			s = runewidth.Wrap(string(v.Buf), f.widths[i])
+		} else {
+			lines := strings.Split(string(v.Buf), "\n")
+			for j, line := range lines {
+				lines[j] = runewidth.Truncate(line, f.widths[i], "…")
+			}
+			s = strings.Join(lines, "\n")
+		}
+
+		res[i] = tblfmt.FormatBytes([]byte(s), nil, 0, false)
+		res[i].Align, res[i].Raw = v.Align, v.Raw
+	}
+	return res, nil
+}
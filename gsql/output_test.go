@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/thda/tds"
+)
+
+// fakeDriver returns the rows registered for each query
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeStmt struct{ query string }
+
+// fakeResult holds the columns and the rows returned by a query
+type fakeResult struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+var fakeResults = map[string]*fakeResult{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, ok := fakeResults[s.query]
+	if !ok {
+		return nil, fmt.Errorf("unknown query %s", s.query)
+	}
+	return &fakeRows{fakeResult: res}, nil
+}
+
+type fakeRows struct {
+	*fakeResult
+	i int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+func init() {
+	sql.Register("gsqlfake", fakeDriver{})
+}
+
+// fakeResultSet returns a result set holding the given rows
+func fakeResultSet(t *testing.T, cols []string, rows ...[]driver.Value) *resultSet {
+	query := fmt.Sprintf("query %d", len(fakeResults))
+	fakeResults[query] = &fakeResult{cols: cols, rows: rows}
+
+	db, err := sql.Open("gsqlfake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resultSet{Rows: r}
+}
+
+// the encoders write the rows with the default settings
+func TestEncoders(t *testing.T) {
+	defer func(w int) { width = w }(width)
+	width = 0
+	cols := []string{"id", "name", "note"}
+	rows := [][]driver.Value{
+		{int64(1), "a,b", nil},
+		{int64(2), "x", "line1\nline2"},
+	}
+
+	for _, test := range []struct {
+		name     string
+		encode   func(w io.Writer, rs *resultSet) error
+		expected string
+	}{
+		{"csv", encodeCSV, "id,name,note\n1,\"a,b\",\n2,x,\"line1\nline2\"\n"},
+		{"raw", encodeRaw, "id name note\n1 a,b \n2 x line1\nline2\n"},
+		{"json", encodeJSON, `{"id":1,"name":"a,b","note":null}` + "\n" +
+			`{"id":2,"name":"x","note":"line1\nline2"}` + "\n"},
+		{"vertical", encodeVertical, "-[ RECORD 1 ]----\nid   | 1\nname | a,b\nnote | \n" +
+			"-[ RECORD 2 ]----\nid   | 2\nname | x\nnote | line1\n     | line2\n"},
+	} {
+		var b bytes.Buffer
+		rs := fakeResultSet(t, cols, rows...)
+		if err := test.encode(&b, rs); err != nil {
+			t.Errorf("%s: encoding failed: %s", test.name, err)
+			continue
+		}
+		rs.Close()
+		if b.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, b.String())
+		}
+	}
+}
+
+// nothing is written for the results without columns
+func TestEncodeNoColumns(t *testing.T) {
+	for _, encode := range []func(w io.Writer, rs *resultSet) error{
+		encodeCSV, encodeRaw, encodeJSON, encodeVertical} {
+		var b bytes.Buffer
+		if err := encode(&b, fakeResultSet(t, nil)); err != nil || b.Len() != 0 {
+			t.Errorf("expected no output, got %q and %v", b.String(), err)
+		}
+	}
+}
+
+// the values keep their type in JSON
+func TestTypedJSONValue(t *testing.T) {
+	var num tds.Num
+	if err := num.Scan("42"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{"text", `"text"`},
+		{true, "true"},
+		{int64(-3), "-3"},
+		{uint64(3), "3"},
+		{1.5, "1.5"},
+		{math.NaN(), `"NaN"`},
+		{math.Inf(1), `"+Inf"`},
+		{math.Inf(-1), `"-Inf"`},
+		{[]byte{1, 2}, `"AQI="`},
+		{num, "42"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC), `"2020-01-02T03:04:05.000000006Z"`},
+		{int32(7), `"7"`},
+	} {
+		b, err := json.Marshal(typedJSONValue(test.value))
+		if err != nil {
+			t.Errorf("marshal of %v failed: %s", test.value, err)
+			continue
+		}
+		if string(b) != test.expected {
+			t.Errorf("expected %s for %v, got %s", test.expected, test.value, b)
+		}
+	}
+}
+
+// fields are only quoted when required, unless --csv-quote-all is given
+func TestWriteCSV(t *testing.T) {
+	defer func(d string, all, crlf bool) {
+		csvDelimiter, csvQuoteAll, csvCRLF = d, all, crlf
+	}(csvDelimiter, csvQuoteAll, csvCRLF)
+
+	for _, test := range []struct {
+		delimiter string
+		quoteAll  bool
+		crlf      bool
+		record    []string
+		expected  string
+	}{
+		{",", false, false, []string{"a", "b"}, "a,b\n"},
+		{",", false, false, []string{"a,b", `say "hi"`}, "\"a,b\",\"say \"\"hi\"\"\"\n"},
+		{",", false, false, []string{" padded", "line\nbreak", ""}, "\" padded\",\"line\nbreak\",\n"},
+		{";", false, false, []string{"a,b", "c;d"}, "a,b;\"c;d\"\n"},
+		{"\t", false, true, []string{"a", "b"}, "a\tb\r\n"},
+		{",", true, false, []string{"a", ""}, "\"a\",\"\"\n"},
+	} {
+		csvDelimiter, csvQuoteAll, csvCRLF = test.delimiter, test.quoteAll, test.crlf
+		var b bytes.Buffer
+		if err := writeCSV(&b, test.record); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("expected %q for %q, got %q", test.expected, test.record, b.String())
+		}
+	}
+}
+
+func TestCheckCSVDelimiter(t *testing.T) {
+	for _, test := range []struct {
+		delimiter string
+		valid     bool
+	}{
+		{",", true},
+		{";", true},
+		{"\t", true},
+		{"§", true},
+		{"", false},
+		{",,", false},
+		{`"`, false},
+		{"\n", false},
+		{"\r", false},
+	} {
+		if err := checkCSVDelimiter(test.delimiter); (err == nil) != test.valid {
+			t.Errorf("expected valid=%t for %q, got %v", test.valid, test.delimiter, err)
+		}
+	}
+}
+
+func TestNumFormat(t *testing.T) {
+	var num tds.Num
+	if err := num.Scan("1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		pattern  string
+		value    interface{}
+		expected string
+		ok       bool
+	}{
+		{"", int64(1234), "", false},
+		{"#,##0.00", "1234", "", false},
+		{"#,##0.00", int64(1234567), "1,234,567", true},
+		{"#,##0.00", -1234.5, "-1,234.50", true},
+		{"#,##0.00", num, "1,234.00", true},
+		{"#,##0", uint64(999), "999", true},
+		{"0.0", 3.14159, "3.1", true},
+		{"0", 2.5, "2", true},
+	} {
+		var n numFormat
+		if err := n.Set(test.pattern); err != nil {
+			t.Errorf("invalid pattern %s: %s", test.pattern, err)
+			continue
+		}
+		s, ok := n.format(test.value)
+		if s != test.expected || ok != test.ok {
+			t.Errorf("%s: expected %q (%t) for %v, got %q (%t)",
+				test.pattern, test.expected, test.ok, test.value, s, ok)
+		}
+	}
+
+	for _, pattern := range []string{"abc", "0.0.0", "#,##0.00 €"} {
+		var n numFormat
+		if err := n.Set(pattern); err == nil {
+			t.Errorf("expected an error for the pattern %s", pattern)
+		}
+	}
+}
+
+// the dates and the numbers are written with --datefmt and --numfmt
+func TestFormatValue(t *testing.T) {
+	defer func(d string, n *numFormat) { dateFormat, numFmt = d, n }(dateFormat, numFmt)
+
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		dateFormat string
+		numFormat  string
+		value      interface{}
+		expected   string
+	}{
+		{"2006-01-02 15:04:05", "", date, "2020-01-02 03:04:05"},
+		{"02/01/2006", "", date, "02/01/2020"},
+		{"Jan 2 2006 3:04PM", "", date, "Jan 2 2020 3:04AM"},
+		{"2006-01-02", "", int64(1234), "1234"},
+		{"2006-01-02", "#,##0", int64(1234), "1,234"},
+		{"2006-01-02", "0.00", 1.5, "1.50"},
+		{"2006-01-02", "", 1.5, "1.5"},
+		{"2006-01-02", "#,##0", "1234", "1234"},
+		{"2006-01-02", "", []byte{0xca, 0xfe}, "0xcafe"},
+	} {
+		dateFormat, numFmt = test.dateFormat, &numFormat{}
+		if err := numFmt.Set(test.numFormat); err != nil {
+			t.Fatal(err)
+		}
+		if s := formatValue(test.value); s != test.expected {
+			t.Errorf("%s %s: expected %s for %v, got %s", test.dateFormat, test.numFormat,
+				test.expected, test.value, s)
+		}
+	}
+}
+func TestColumnWidths(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{"name=10", "name=10", true},
+		{"name=10, Descr=5", "descr=5,name=10", true},
+		{"a=b=3", "a=b=3", true},
+		{"name=0", "name=0", true},
+		{"name", "", false},
+		{"=5", "", false},
+		{"name=x", "", false},
+		{"name=-1", "", false},
+	} {
+		c := columnWidths{}
+		err := c.Set(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%t for %s, got %v", test.valid, test.value, err)
+			continue
+		}
+		if err == nil && c.String() != test.expected {
+			t.Errorf("expected %s for %s, got %s", test.expected, test.value, c.String())
+		}
+	}
+}
+
+// the batch is cancelled once the row limit is reached, if rows are left
+func TestMaxRows(t *testing.T) {
+	cols := []string{"id"}
+	rows := [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}}
+
+	for _, test := range []struct {
+		maxRows   int
+		fetched   int
+		cancelled bool
+	}{
+		{0, 3, false},
+		{1, 1, true},
+		{2, 2, true},
+		{3, 3, false},
+		{4, 3, false},
+	} {
+		cancelled := 0
+		rs := fakeResultSet(t, cols, rows...)
+		rs.maxRows, rs.cancel = test.maxRows, func() { cancelled++ }
+
+		fetched := 0
+		for rs.Next() {
+			fetched++
+		}
+		rs.Next()
+		if fetched != test.fetched || (cancelled == 1) != test.cancelled || cancelled > 1 ||
+			rs.truncated != test.cancelled {
+			t.Errorf("max rows %d: expected %d rows, cancelled %t, got %d rows, cancelled %d times",
+				test.maxRows, test.fetched, test.cancelled, fetched, cancelled)
+		}
+		if test.cancelled && (rs.NextResultSet() || rs.Err() != nil) {
+			t.Errorf("max rows %d: expected no result set left after the cancel", test.maxRows)
+		}
+		rs.Close()
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/thda/tds"
+)
+
+// paramValues holds the values of the :name placeholders,
+// given as name=value pairs.
+type paramValues map[string]string
+
+func (p paramValues) String() string {
+	pairs := make([]string, 0, len(p))
+	for name, value := range p {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a name=value pair
+func (p paramValues) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid parameter '%s', expected name=value", s)
+	}
+	p[s[:i]] = s[i+1:]
+	return nil
+}
+
+// paramRows reads the CSV file giving the parameters' values for each execution.
+// The first record holds the names of the parameters.
+func paramRows(path string) (rows []paramValues, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters file %s: %s", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty parameters file %s", path)
+	}
+	for _, record := range records[1:] {
+		row := make(paramValues, len(record))
+		for i, name := range records[0] {
+			row[strings.TrimSpace(name)] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// bind replaces the :name placeholders of a batch by question marks,
+// and returns the values to pass along, in order.
+// Placeholders in string literals and comments are left alone.
+func bind(batch string, values ...paramValues) (string, []interface{}, error) {
+	var b strings.Builder
+	var args []interface{}
+	in := rune(0) // quote, '-' or '*' when in a literal or a comment
+	runes := []rune(batch)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case in == '\'' || in == '"':
+			if r == in {
+				in = 0
+			}
+		case in == '-':
+			if r == '\n' {
+				in = 0
+			}
+		case in == '*':
+			if r == '*' && next == '/' {
+				b.WriteString("*/")
+				i++
+				in = 0
+				continue
+			}
+		case r == '\'' || r == '"':
+			in = r
+		case r == '-' && next == '-':
+			in = '-'
+		case r == '/' && next == '*':
+			in = '*'
+			b.WriteString("/*")
+			i++
+			continue
+		case r == ':' && (unicode.IsLetter(next) || next == '_') &&
+			(i == 0 || !isParamRune(runes[i-1]) && runes[i-1] != ':'):
+			j := i + 1
+			for j < len(runes) && isParamRune(runes[j]) {
+				j++
+			}
+			name := string(runes[i+1 : j])
+			value, ok := lookupParam(name, values)
+			if !ok {
+				return "", nil, fmt.Errorf("no value for parameter :%s", name)
+			}
+			b.WriteByte('?')
+			args = append(args, value)
+			i = j - 1
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), args, nil
+}
+
+// isParamRune returns true for the runes of a parameter name
+func isParamRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// lookupParam returns the value of a parameter, the last values taking precedence
+func lookupParam(name string, values []paramValues) (string, bool) {
+	for i := len(values) - 1; i >= 0; i-- {
+		if v, ok := values[i][name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// execParams runs a batch, binding its placeholders to the --param values.
+// With --param-csv, the batch is run once per record of the file.
+func execParams(s *session, o *output, batch string) error {
+	// batches are sent as is without parameters, or without placeholders
+	if len(params) == 0 && csvParams == nil {
+		return execRetry(s, o, batch)
+	}
+	if _, args, err := bind(batch); err == nil && len(args) == 0 {
+		return execRetry(s, o, batch)
+	}
+
+	if csvParams == nil {
+		query, args, err := bind(batch, params)
+		if err != nil {
+			fmt.Fprintln(stdout, location(0)+err.Error())
+			return err
+		}
+		return execRetry(s, o, query, args...)
+	}
+
+	for i, row := range csvParams {
+		query, args, err := bind(batch, params, row)
+		if err != nil {
+			fmt.Fprintf(stdout, "%srecord %d of %s: %s\n", location(0), i+1, paramFile, err)
+			return err
+		}
+		// the query is the same for all the records, only the values change
+		if i == 0 {
+			if err = s.prepare(query); err != nil {
+				if _, ok := err.(tds.SybError); !ok {
+					fmt.Fprintln(stdout, location(0)+err.Error())
+				}
+				return err
+			}
+			defer s.unprepare()
+		}
+		if err = execRetry(s, o, query, args...); err != nil {
+			fmt.Fprintf(stdout, "%sfailed on record %d of %s\n", location(0), i+1, paramFile)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// the placeholders are replaced by question marks, except in literals and comments
+func TestBind(t *testing.T) {
+	values := paramValues{"a": "1", "b": "2", "long_name": "3"}
+
+	for _, test := range []struct {
+		batch    string
+		query    string
+		args     []interface{}
+		hasError bool
+	}{
+		{"select 1", "select 1", nil, false},
+		{"select :a, :b", "select ?, ?", []interface{}{"1", "2"}, false},
+		{"select :a+:a", "select ?+?", []interface{}{"1", "1"}, false},
+		{"select :long_name", "select ?", []interface{}{"3"}, false},
+		{"select ':a', \":b\", :a", "select ':a', \":b\", ?", []interface{}{"1"}, false},
+		{"select 1 -- :a\n, :b", "select 1 -- :a\n, ?", []interface{}{"2"}, false},
+		{"select /* :a */ :b", "select /* :a */ ?", []interface{}{"2"}, false},
+		{"select x::a, y:a, : a", "select x::a, y:a, : a", nil, false},
+		{"select :c", "", nil, true},
+	} {
+		query, args, err := bind(test.batch, values)
+		if (err != nil) != test.hasError {
+			t.Errorf("%s: expected error=%t, got %v", test.batch, test.hasError, err)
+			continue
+		}
+		if query != test.query || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: expected %q %v, got %q %v", test.batch, test.query, test.args, query, args)
+		}
+	}
+}
+
+// the values of the --param-csv records take precedence over --param
+func TestBindPrecedence(t *testing.T) {
+	query, args, err := bind("select :a, :b", paramValues{"a": "1", "b": "2"}, paramValues{"a": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "select ?, ?" || !reflect.DeepEqual(args, []interface{}{"3", "2"}) {
+		t.Errorf("expected the values 3 and 2, got %q %v", query, args)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// delay before displaying the progress, to avoid flickering on quick queries
+const progressDelay = time.Second
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// progress displays on stderr the elapsed time while waiting for the server,
+// then the number of rows fetched so far.
+type progress struct {
+	sync.Mutex
+	start time.Time
+	rows  int
+	// a line is displayed and must be cleared before any other output
+	shown bool
+	frame int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// currentProgress is the progress of the running batch, if any.
+// Messages sent by the server must clear it before being printed.
+var currentProgress *progress
+
+// startProgress starts displaying the progress of a batch.
+// Returns nil if stderr is not a terminal or if the progress is disabled.
+func startProgress() *progress {
+	if !showProgress || !readline.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	p := &progress{start: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case now := <-ticker.C:
+				if now.Sub(p.start) >= progressDelay {
+					p.draw(now)
+				}
+			}
+		}
+	}()
+	currentProgress = p
+	return p
+}
+
+// draw refreshes the progress line
+func (p *progress) draw(now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	elapsed := now.Sub(p.start).Truncate(100 * time.Millisecond)
+	p.frame = (p.frame + 1) % len(spinnerFrames)
+	if p.rows == 0 {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s %s", spinnerFrames[p.frame], elapsed)
+	} else {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s %d rows fetched (%s)", spinnerFrames[p.frame], p.rows, elapsed)
+	}
+	p.shown = true
+}
+
+// row increments the number of rows fetched
+func (p *progress) row() {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	p.rows++
+	p.Unlock()
+}
+
+// clear erases the progress line, it will be redrawn at the next tick
+func (p *progress) clear() {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.shown = false
+	}
+}
+
+// Stop stops and erases the progress display
+func (p *progress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.clear()
+	currentProgress = nil
+}
+
+// progressWriter clears the progress line before writing the output
+type progressWriter struct {
+	io.Writer
+	p *progress
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.p.clear()
+	return w.Writer.Write(b)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+var (
+	// file logging the batches run, as JSON lines
+	queryLogFile string
+	queryLog     *os.File
+)
+
+// logEntry describes a batch run, in the query log
+type logEntry struct {
+	Time     time.Time `json:"time"`
+	Session  string    `json:"session"`
+	Server   string    `json:"server"`
+	Database string    `json:"database"`
+	Batch    string    `json:"batch"`
+	// duration in milliseconds
+	Duration float64 `json:"duration"`
+	// rows returned by the result sets, and row count of the last statement
+	Rows     int    `json:"rows"`
+	Affected *int64 `json:"affected,omitempty"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+}
+
+// openQueryLog opens the query log for appending
+func openQueryLog(path string) (err error) {
+	queryLog, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	return err
+}
+
+// logBatch appends a batch run to the query log, if any
+func logBatch(s *session, batch string, start time.Time, rows int, err error) {
+	if queryLog == nil {
+		return
+	}
+
+	e := logEntry{Time: start, Session: s.name, Server: s.host, Database: s.database,
+		Batch: batch, Duration: float64(time.Since(start)) / float64(time.Millisecond),
+		Rows: rows, Outcome: "ok"}
+	if count, ok := s.affectedRows(); ok {
+		e.Affected = &count
+	}
+	if err != nil {
+		e.Outcome, e.Error = "error", err.Error()
+	} else if batchSeverity > 10 {
+		e.Outcome = "error"
+	}
+
+	b, jerr := json.Marshal(e)
+	if jerr != nil {
+		return
+	}
+	queryLog.Write(append(b, '\n'))
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// column of a table, as described by the catalog
+type column struct {
+	name, typ string
+	nullable  bool
+}
+
+func (c column) String() string {
+	if c.nullable {
+		return c.typ + " null"
+	}
+	return c.typ + " not null"
+}
+
+// index of a table
+type index struct {
+	name              string
+	unique, clustered bool
+	// columns, followed by desc when sorted in descending order
+	keys string
+}
+
+// kind returns the unique and clustered keywords of the index
+func (i index) kind() (kind string) {
+	if i.unique {
+		kind += "unique "
+	}
+	if i.clustered {
+		kind += "clustered "
+	}
+	return kind
+}
+
+func (i index) String() string {
+	return i.kind() + "(" + i.keys + ")"
+}
+
+// schema describes the objects of a database, named owner.name
+type schema struct {
+	// columns of each table, in order
+	tables map[string][]column
+	// indexes of each table, in order of name
+	indexes map[string][]index
+	// text of the procedures, views and triggers, by name,
+	// and their type
+	modules     map[string]string
+	moduleTypes map[string]string
+}
+
+// columnsQuery lists the columns of the user tables
+const columnsQuery = `select user_name(o.uid) + '.' + o.name, c.name, t.name, c.length, isnull(c.prec, 0), isnull(c.scale, 0),
+	convert(int, c.status & 8)
+from sysobjects o, syscolumns c, systypes t
+where o.type = 'U' and c.id = o.id and t.usertype = c.usertype
+order by 1, c.colid`
+
+// modulesQuery returns the text of the procedures, views and triggers
+const modulesQuery = `select user_name(o.uid) + '.' + o.name, o.type, c.text
+from sysobjects o, syscomments c
+where o.type in ('P', 'V', 'TR') and c.id = o.id
+order by 1, c.number, c.colid2, c.colid`
+
+// maximum number of keys of an index
+const maxIndexKeys = 16
+
+// indexesQuery lists the indexes of the user tables, with their keys
+// and their sort order
+func indexesQuery() string {
+	key := func(n int) string {
+		return fmt.Sprintf(`index_col(o.name, i.indid, %d, o.uid)
+		+ case when index_colorder(o.name, i.indid, %d, o.uid) = 'DESC' then ' desc' else '' end`, n, n)
+	}
+	keys := key(1)
+	for n := 2; n <= maxIndexKeys; n++ {
+		keys += fmt.Sprintf(`
+	+ case when index_col(o.name, i.indid, %d, o.uid) is null then '' else ', ' + %s end`, n, key(n))
+	}
+	return `select user_name(o.uid) + '.' + o.name, i.name, convert(int, i.status & 2),
+	case when i.indid = 1 or i.status2 & 512 = 512 then 1 else 0 end,
+	` + keys + `
+from sysobjects o, sysindexes i
+where o.type = 'U' and i.id = o.id and i.indid > 0 and i.indid < 255
+order by 1, i.name`
+}
+
+// typeName returns the declaration of a datatype
+func typeName(name string, length, prec, scale int) string {
+	switch name {
+	case "char", "varchar", "binary", "varbinary", "nchar", "nvarchar":
+		return fmt.Sprintf("%s(%d)", name, length)
+	case "unichar", "univarchar":
+		return fmt.Sprintf("%s(%d)", name, length/2)
+	case "numeric", "decimal", "numericn", "decimaln":
+		return fmt.Sprintf("%s(%d,%d)", strings.TrimSuffix(name, "n"), prec, scale)
+	}
+	return name
+}
+
+// queryEach calls fn for each row returned by a query
+func queryEach(s *session, query string, fn func(rows *sql.Rows) error) error {
+	rows, err := s.conn.QueryContext(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// loadSchema reads the schema of the session's current database
+func loadSchema(s *session) (*schema, error) {
+	sc := &schema{tables: map[string][]column{}, indexes: map[string][]index{},
+		modules: map[string]string{}, moduleTypes: map[string]string{}}
+
+	err := queryEach(s, columnsQuery, func(rows *sql.Rows) error {
+		var table, name, typ string
+		var length, prec, scale, nullable int
+		if err := rows.Scan(&table, &name, &typ, &length, &prec, &scale, &nullable); err != nil {
+			return err
+		}
+		sc.tables[table] = append(sc.tables[table],
+			column{name: name, typ: typeName(typ, length, prec, scale), nullable: nullable != 0})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryEach(s, indexesQuery(), func(rows *sql.Rows) error {
+		var table, name, keys string
+		var unique, clustered int
+		if err := rows.Scan(&table, &name, &unique, &clustered, &keys); err != nil {
+			return err
+		}
+		sc.indexes[table] = append(sc.indexes[table],
+			index{name: name, unique: unique != 0, clustered: clustered != 0, keys: keys})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryEach(s, modulesQuery, func(rows *sql.Rows) error {
+		var name, typ, text string
+		if err := rows.Scan(&name, &typ, &text); err != nil {
+			return err
+		}
+		sc.modules[name] += text
+		sc.moduleTypes[name] = strings.TrimSpace(typ)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// moduleKinds names the types of modules
+var moduleKinds = map[string]string{"P": "procedure", "V": "view", "TR": "trigger"}
+
+// tableNames returns the sorted names of the tables of either schema
+func tableNames(a, b map[string][]column) []string {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// moduleNames returns the sorted names of the modules of either schema
+func moduleNames(a, b map[string]string) []string {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// schemaDiff reports the differences of the schemas,
+// and the statements to apply on the destination to match the source.
+type schemaDiff struct {
+	w       io.Writer
+	alter   bool
+	changes int
+}
+
+// report prints a difference, followed by the statements fixing it
+func (d *schemaDiff) report(statements []string, format string, args ...interface{}) {
+	d.changes++
+	fmt.Fprintf(d.w, format+"\n", args...)
+	if d.alter {
+		for _, stmt := range statements {
+			fmt.Fprintf(d.w, "\t%s\n", strings.Replace(stmt, "\n", "\n\t", -1))
+		}
+	}
+}
+
+// normalize collapses the white spaces of a module's text
+func normalize(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// diff compares the tables, columns, indexes and modules of the schemas
+func (d *schemaDiff) diff(src, dst *schema) {
+	for _, table := range tableNames(src.tables, dst.tables) {
+		scols, sok := src.tables[table]
+		dcols, dok := dst.tables[table]
+		switch {
+		case !dok:
+			decls := make([]string, len(scols))
+			for i, c := range scols {
+				decls[i] = c.name + " " + c.String()
+			}
+			d.report([]string{fmt.Sprintf("create table %s (\n\t%s\n)", table, strings.Join(decls, ",\n\t"))},
+				"table %s: only in source", table)
+		case !sok:
+			// the indexes go along
+			d.report([]string{"drop table " + table}, "table %s: only in destination", table)
+			continue
+		default:
+			d.diffColumns(table, scols, dcols)
+		}
+		d.diffIndexes(table, src.indexes[table], dst.indexes[table])
+	}
+
+	for _, name := range moduleNames(src.modules, dst.modules) {
+		stext, sok := src.modules[name]
+		dtext, dok := dst.modules[name]
+		kind := moduleKinds[src.moduleTypes[name]]
+		if !sok {
+			kind = moduleKinds[dst.moduleTypes[name]]
+		}
+		switch {
+		case !dok:
+			d.report([]string{strings.TrimSpace(stext), "go"}, "%s %s: only in source", kind, name)
+		case !sok:
+			d.report([]string{fmt.Sprintf("drop %s %s", kind, name)}, "%s %s: only in destination", kind, name)
+		case normalize(stext) != normalize(dtext):
+			d.report([]string{fmt.Sprintf("drop %s %s", kind, name), "go", strings.TrimSpace(stext), "go"},
+				"%s %s: text differs", kind, name)
+		}
+	}
+}
+
+// diffColumns compares the columns of a table present in both schemas
+func (d *schemaDiff) diffColumns(table string, scols, dcols []column) {
+	byName := func(cols []column) map[string]column {
+		m := make(map[string]column, len(cols))
+		for _, c := range cols {
+			m[c.name] = c
+		}
+		return m
+	}
+	smap, dmap := byName(scols), byName(dcols)
+
+	for _, c := range scols {
+		dc, ok := dmap[c.name]
+		switch {
+		case !ok:
+			d.report([]string{fmt.Sprintf("alter table %s add %s %s", table, c.name, c)},
+				"column %s.%s: only in source, %s", table, c.name, c)
+		case dc != c:
+			d.report([]string{fmt.Sprintf("alter table %s modify %s %s", table, c.name, c)},
+				"column %s.%s: %s in source, %s in destination", table, c.name, c, dc)
+		}
+	}
+	for _, c := range dcols {
+		if _, ok := smap[c.name]; !ok {
+			d.report([]string{fmt.Sprintf("alter table %s drop %s", table, c.name)},
+				"column %s.%s: only in destination, %s", table, c.name, c)
+		}
+	}
+}
+
+// diffIndexes compares the indexes of a table, the source's being created
+// with the table when it is missing in the destination
+func (d *schemaDiff) diffIndexes(table string, sidx, didx []index) {
+	byName := func(idx []index) map[string]index {
+		m := make(map[string]index, len(idx))
+		for _, i := range idx {
+			m[i.name] = i
+		}
+		return m
+	}
+	smap, dmap := byName(sidx), byName(didx)
+
+	for _, si := range sidx {
+		create := fmt.Sprintf("create %sindex %s on %s (%s)", si.kind(), si.name, table, si.keys)
+		di, ok := dmap[si.name]
+		switch {
+		case !ok:
+			d.report([]string{create}, "index %s.%s: only in source %s", table, si.name, si)
+		case di != si:
+			d.report([]string{fmt.Sprintf("drop index %s.%s", table, si.name), create},
+				"index %s.%s: %s in source, %s in destination", table, si.name, si, di)
+		}
+	}
+	for _, di := range didx {
+		if _, ok := smap[di.name]; !ok {
+			d.report([]string{fmt.Sprintf("drop index %s.%s", table, di.name)},
+				"index %s.%s: only in destination %s", table, di.name, di)
+		}
+	}
+}
+
+// diffTarget returns the connection parameters of a side of the comparison,
+// given as [server][/database]
+func diffTarget(arg string) (t target, err error) {
+	name, db := arg, ""
+	if i := strings.LastIndex(arg, "/"); i >= 0 {
+		name, db = arg[:i], arg[i+1:]
+	}
+	t = defaultTarget()
+	if name != "" {
+		if t, err = lookupTarget(name); err != nil {
+			return t, err
+		}
+	}
+	if db != "" {
+		t.database = db
+	}
+	return t, nil
+}
+
+// schemaDiffCommand compares the schemas of two databases
+func schemaDiffCommand(args []string) error {
+	var alter bool
+	fs := flag.NewFlagSet("schemadiff", flag.ContinueOnError)
+	fs.BoolVar(&alter, "alter", false, "print the statements to run on the destination to match the source")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: schemadiff [--alter] [server][/database] [server][/database]")
+	}
+
+	var schemas [2]*schema
+	for i, name := range []string{"source", "destination"} {
+		t, err := diffTarget(fs.Arg(i))
+		if err != nil {
+			return err
+		}
+		s, err := newSession(name, t)
+		if err != nil {
+			return fmt.Errorf("failed to connect to the %s: %s", name, err)
+		}
+		schemas[i], err = loadSchema(s)
+		s.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read the %s schema: %s", name, err)
+		}
+	}
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	d := &schemaDiff{w: w, alter: alter}
+	d.diff(schemas[0], schemas[1])
+	if d.changes == 0 {
+		fmt.Fprintln(w, "no difference")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// the objects of different owners are not compared, and the sort order of the keys is
+func TestSchemaDiff(t *testing.T) {
+	cols := []column{{name: "id", typ: "int"}}
+	src := &schema{
+		tables: map[string][]column{"dbo.t": cols, "other.t": cols},
+		indexes: map[string][]index{
+			"dbo.t":   {{name: "ix", keys: "id desc"}},
+			"other.t": {{name: "ix", keys: "id"}},
+		},
+		modules:     map[string]string{"dbo.p": "create procedure p as select 1"},
+		moduleTypes: map[string]string{"dbo.p": "P"},
+	}
+	dst := &schema{
+		tables: map[string][]column{"dbo.t": cols, "other.t": cols},
+		indexes: map[string][]index{
+			"dbo.t":   {{name: "ix", keys: "id"}},
+			"other.t": {{name: "ix", keys: "id"}},
+		},
+		modules:     map[string]string{"other.p": "create procedure p as select 1"},
+		moduleTypes: map[string]string{"other.p": "P"},
+	}
+
+	var b bytes.Buffer
+	d := &schemaDiff{w: &b}
+	d.diff(src, dst)
+	expected := `index dbo.t.ix: (id desc) in source, (id) in destination
+procedure dbo.p: only in source
+procedure other.p: only in destination
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limits of the HTTP server. The write timeout bounds the batches,
+// which can be shortened with the command timeout.
+const (
+	maxQuerySize       = 1 << 20
+	serveHeaderTimeout = 10 * time.Second
+	serveReadTimeout   = time.Minute
+	serveWriteTimeout  = 30 * time.Minute
+	serveIdleTimeout   = 2 * time.Minute
+)
+
+// content type of the formats served over HTTP
+var serveFormats = map[string]string{
+	"json": "application/x-ndjson",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+// queryHandler runs the SQL sent over HTTP, each request on its own connection
+// in the state of the session, so that the requests do not share their database,
+// options or transactions. The requests are serialized.
+type queryHandler struct {
+	sync.Mutex
+	s     *session
+	token string
+}
+
+func (h *queryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "the query must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := serveFormats[format]
+	if !ok {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	// the query is either the body, or the q field of a form
+	r.Body = http.MaxBytesReader(w, r.Body, maxQuerySize)
+	batch := r.FormValue("q")
+	if batch == "" {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batch = string(b)
+	}
+	if strings.TrimSpace(batch) == "" {
+		http.Error(w, "empty query", http.StatusBadRequest)
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	// a client going away cancels its batch
+	s, err := h.s.fork(r.Context())
+	if err != nil {
+		http.Error(w, "failed to connect: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.conn.Close()
+
+	// the results are streamed: the errors raised once the output started
+	// are reported in the Gsql-Error trailer
+	rw := &responseWriter{ResponseWriter: w, contentType: contentType}
+	o := newOutput(rw, format)
+	err = execRetry(s, o, batch)
+	o.Flush()
+	switch {
+	case err != nil && !rw.started:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		w.Header().Set("Gsql-Error", strings.TrimSpace(err.Error()))
+	case !rw.started:
+		w.Header().Set("Content-Type", contentType)
+	}
+}
+
+// responseWriter sends the headers with the first results
+type responseWriter struct {
+	http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.Header().Set("Content-Type", w.contentType)
+		w.Header().Set("Trailer", "Gsql-Error")
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// serve exposes the session over HTTP, until the server fails.
+// Clients authenticate with a bearer token, generated if not given.
+// The server listens on localhost unless a host is given,
+// and serves HTTPS if a certificate is given.
+func serve(s *session, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address '%s': %s", addr, err)
+	}
+	if host == "" {
+		addr = net.JoinHostPort("localhost", port)
+	}
+
+	token := os.Getenv("GSQL_SERVE_TOKEN")
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(b)
+		fmt.Fprintln(os.Stderr, "token:", token)
+	}
+	fmt.Fprintln(os.Stderr, "serving on", addr)
+
+	// the connections of the requests are discarded once done
+	s.db.SetMaxIdleConns(0)
+
+	mux := http.NewServeMux()
+	mux.Handle("/query", &queryHandler{s: s, token: token})
+	srv := &http.Server{Addr: addr, Handler: mux,
+		ReadHeaderTimeout: serveHeaderTimeout, ReadTimeout: serveReadTimeout,
+		WriteTimeout: serveWriteTimeout, IdleTimeout: serveIdleTimeout}
+	if serveCert != "" {
+		return srv.ListenAndServeTLS(serveCert, serveKey)
+	}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// the requests are checked before reaching the server
+func TestQueryHandler(t *testing.T) {
+	h := &queryHandler{token: "secret"}
+
+	for _, test := range []struct {
+		name   string
+		method string
+		url    string
+		auth   string
+		body   string
+		status int
+	}{
+		{"no token", "POST", "/query", "", "select 1", http.StatusUnauthorized},
+		{"no scheme", "POST", "/query", "secret", "select 1", http.StatusUnauthorized},
+		{"wrong token", "POST", "/query", "Bearer other", "select 1", http.StatusUnauthorized},
+		{"wrong scheme", "POST", "/query", "Basic secret", "select 1", http.StatusUnauthorized},
+		{"not posted", "GET", "/query", "Bearer secret", "", http.StatusMethodNotAllowed},
+		{"unknown format", "POST", "/query?format=xml", "Bearer secret", "select 1", http.StatusBadRequest},
+		{"empty query", "POST", "/query", "Bearer secret", "  ", http.StatusBadRequest},
+		{"query too large", "POST", "/query", "Bearer secret", strings.Repeat("x", maxQuerySize+1),
+			http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: expected the status %d, got %d", test.name, test.status, w.Code)
+		}
+	}
+}
+
+// the headers are sent with the first results
+func TestResponseWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: w, contentType: "text/csv"}
+	if rw.started {
+		t.Fatal("the response started before the first write")
+	}
+	rw.Write([]byte("a,b\n"))
+	rw.Write([]byte("1,2\n"))
+	if !rw.started || w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" ||
+		w.Header().Get("Trailer") != "Gsql-Error" || w.Body.String() != "a,b\n1,2\n" {
+		t.Errorf("unexpected response: %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/thda/tds"
+)
+
+// matches the set statements of a batch, to replay them on reconnection
+var setRe = regexp.MustCompile(`(?im)^\s*set\s+([[:alpha:]_][^;\n=]*?)\s+([^\s;=]+)\s*;?\s*$`)
+
+// target holds the connection parameters which can differ between sessions
+type target struct {
+	server, user, password, database string
+	loginScript                      string
+}
+
+// defaultTarget returns the connection parameters given on the command line
+func defaultTarget() target {
+	t := target{server: server, user: userName, password: password, database: database,
+		loginScript: loginScript}
+	if t.loginScript == "" {
+		t.loginScript = cfg.LoginScript
+	}
+	return t
+}
+
+// lookupTarget returns the connection parameters of a server alias
+// defined in the configuration file, or of a host:port.
+func lookupTarget(name string) (t target, err error) {
+	t = defaultTarget()
+	if srv, ok := cfg.Servers[name]; ok {
+		for _, f := range []struct{ dst, src *string }{{&t.server, &srv.Server},
+			{&t.user, &srv.User}, {&t.password, &srv.Password},
+			{&t.database, &srv.Database}, {&t.loginScript, &srv.LoginScript}} {
+			if *f.src != "" {
+				*f.dst = *f.src
+			}
+		}
+		return t, nil
+	}
+	if strings.Contains(name, ":") {
+		t.server = name
+		return t, nil
+	}
+	if _, err = resolve(name); err == nil {
+		t.server = name
+		return t, nil
+	}
+	return t, fmt.Errorf("unknown server '%s'", name)
+}
+
+// resolve returns the addresses to try in turn to reach a server,
+// given as a comma separated list of host:port or server names.
+// A server name stands for its query entries in the interfaces file.
+func resolve(server string) (addrs []string, err error) {
+	for _, name := range strings.Split(server, ",") {
+		var entries []string
+		if entries, err = resolveName(strings.TrimSpace(name)); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, entries...)
+	}
+	return addrs, nil
+}
+
+// resolveName returns the addresses of a host:port or a server name
+func resolveName(server string) ([]string, error) {
+	if strings.Contains(server, ":") {
+		return []string{server}, nil
+	}
+	path := interfacesFile
+	if path == "" {
+		if os.Getenv("SYBASE") == "" {
+			return nil, fmt.Errorf("no interfaces file to look up server %s. Use -I or set $SYBASE", server)
+		}
+		path = filepath.Join(os.Getenv("SYBASE"), "interfaces")
+	}
+	return tds.LookupInterfaces(path, server)
+}
+
+// open sessions, by name, and the one receiving the batches
+var (
+	sessions = make(map[string]*session)
+	current  *session
+)
+
+// session wraps the connection to the server,
+// and keeps track of the state to restore after a reconnection.
+type session struct {
+	name string
+	db   *sql.DB
+	conn *sql.Conn
+
+	// current database, and address of the server reached
+	database string
+	host     string
+
+	// batches run after each connection
+	loginScript string
+
+	// server type and major version, and the words to complete with
+	serverType    string
+	serverVersion int
+	words         []string
+
+	// set statements issued during the session, in order.
+	// The map keeps the index of each option in the list
+	options    []string
+	optionsIdx map[string]int
+
+	// statement prepared for a query run repeatedly, used by execBatch
+	stmt      *sql.Stmt
+	stmtQuery string
+
+	// parent context of the batches, the run's if nil
+	ctx context.Context
+}
+
+// newSession opens a single connection to the server,
+// and registers it under the given name.
+func newSession(name string, t target) (s *session, err error) {
+	s = &session{name: name, optionsIdx: make(map[string]int), loginScript: t.loginScript}
+	addrs, err := resolve(t.server)
+	if err != nil {
+		return nil, err
+	}
+
+	// the driver tries the fallback addresses in order
+	t.server = strings.Join(addrs, ",")
+	if sshBastion != "" {
+		s.db = sql.OpenDB(tds.NewConnector(buildCnxStr(t), sshDialer{bastion: sshBastion, key: sshKey}))
+	} else if s.db, err = sql.Open("tds", buildCnxStr(t)); err != nil {
+		return nil, err
+	}
+
+	// print showplan messages and all
+	s.db.Driver().(tds.ErrorHandler).SetErrorhandler(handleMessage)
+
+	if s.conn, err = s.db.Conn(context.Background()); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	if err = s.runLoginScript(); err != nil {
+		s.conn.Close()
+		s.db.Close()
+		return nil, err
+	}
+	s.saveEnv()
+	if len(addrs) > 1 {
+		fmt.Fprintln(os.Stderr, "connected to", s.host)
+	}
+	s.detectServer()
+	sessions[name] = s
+	return s, nil
+}
+
+// Close releases the connection
+func (s *session) Close() error {
+	delete(sessions, s.name)
+	s.conn.Close()
+	return s.db.Close()
+}
+
+// runLoginScript runs the batches of the login script, if any.
+// The messages are located in the script.
+func (s *session) runLoginScript() error {
+	if s.loginScript == "" {
+		return nil
+	}
+	r, err := newFileBatchReader(s.loginScript, nil)
+	if err != nil {
+		return fmt.Errorf("could not open login script: %s", err)
+	}
+	defer r.Close()
+
+	file, line := batchFile, batchLine
+	defer func() { batchFile, batchLine = file, line }()
+
+	for {
+		batch, err := r.ReadBatch(terminator)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if strings.TrimSpace(batch) == "" {
+			continue
+		}
+		batchFile, batchLine = r.name, r.start
+		if _, err = s.conn.ExecContext(context.Background(), batch); err != nil {
+			// server errors were displayed by the message handler
+			if _, ok := err.(tds.SybError); ok {
+				return fmt.Errorf("login script %s failed", s.loginScript)
+			}
+			return fmt.Errorf("%s%s", location(0), err)
+		}
+	}
+}
+
+// closeSessions closes all the open sessions
+func closeSessions() {
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+// alive checks if the connection is still usable
+func (s *session) alive() bool {
+	return s.conn.PingContext(context.Background()) == nil
+}
+
+// env returns the environment of the connection, as reported by the driver
+func (s *session) env() (env map[string]string) {
+	s.conn.Raw(func(dc interface{}) error {
+		if c, ok := dc.(*tds.Conn); ok {
+			env = c.GetEnv()
+		}
+		return nil
+	})
+	return env
+}
+
+// saveEnv keeps the current database to restore it on reconnection,
+// and the address of the server reached
+func (s *session) saveEnv() {
+	env := s.env()
+	s.database, s.host = env["database"], env["host"]
+}
+
+// affectedRows returns the row count of the last statement run, if any
+func (s *session) affectedRows() (count int64, ok bool) {
+	s.conn.Raw(func(dc interface{}) error {
+		if c, isConn := dc.(*tds.Conn); isConn {
+			count, ok = c.AffectedRows()
+		}
+		return nil
+	})
+	return count, ok
+}
+
+// inTransaction returns true if the last batch left a transaction open
+func (s *session) inTransaction() (open bool) {
+	s.conn.Raw(func(dc interface{}) error {
+		if c, ok := dc.(*tds.Conn); ok {
+			open = c.InTransaction()
+		}
+		return nil
+	})
+	return open
+}
+
+// checkCommand runs a trivial query and prints the connection details
+func checkCommand(s *session, args []string) error {
+	var version string
+	if err := s.conn.QueryRowContext(context.Background(), "select @@version").Scan(&version); err != nil {
+		return err
+	}
+
+	env := s.env()
+	tw := tabwriter.NewWriter(stdout, 0, 8, 1, ' ', 0)
+	for _, kv := range [][2]string{{"host", env["host"]}, {"server type", env["serverType"]},
+		{"version", strings.TrimSpace(version)}, {"database", env["database"]},
+		{"charset", env["charset"]}, {"packet size", env["packetSize"]}, {"ssl", env["ssl"]}} {
+		fmt.Fprintf(tw, "%s:\t%s\n", kv[0], kv[1])
+	}
+	return tw.Flush()
+}
+
+// sessionNames returns the names of the open sessions, sorted
+func sessionNames() []string {
+	names := make([]string, 0, len(sessions))
+	for name := range sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// connectCommand opens a session or switches to an already open one
+func connectCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		for _, name := range sessionNames() {
+			mark := " "
+			if sessions[name] == current {
+				mark = "*"
+			}
+			fmt.Fprintf(stdout, "%s %s\n", mark, name)
+		}
+		return nil
+	}
+
+	name := args[0]
+	if s, ok := sessions[name]; ok {
+		current = s
+		return nil
+	}
+
+	t, err := lookupTarget(name)
+	if err != nil {
+		return err
+	}
+	if s, err = newSession(name, t); err != nil {
+		return fmt.Errorf("failed to connect to %s: %s", name, err)
+	}
+	current = s
+	return nil
+}
+
+// disconnectCommand closes a session
+func disconnectCommand(s *session, args []string) error {
+	if len(args) > 0 {
+		var ok bool
+		if s, ok = sessions[args[0]]; !ok {
+			return fmt.Errorf("no session named '%s'", args[0])
+		}
+	}
+	if len(sessions) == 1 {
+		return errors.New("cannot close the last session")
+	}
+	s.Close()
+	if s == current {
+		current = sessions[sessionNames()[0]]
+		fmt.Fprintln(stdout, "switched to", current.name)
+	}
+	return nil
+}
+
+// onAll is set to run the next batch on all the open sessions
+var onAll bool
+
+func onAllCommand(s *session, args []string) error {
+	onAll = true
+	return nil
+}
+
+// track records the set statements of a successful batch.
+// The last value of an option wins.
+func (s *session) track(batch string) {
+	for _, m := range setRe.FindAllStringSubmatch(batch, -1) {
+		option := strings.ToLower(strings.Join(strings.Fields(m[1]), " "))
+		stmt := "set " + m[1] + " " + m[2]
+		if i, ok := s.optionsIdx[option]; ok {
+			s.options[i] = stmt
+			continue
+		}
+		s.optionsIdx[option] = len(s.options)
+		s.options = append(s.options, stmt)
+	}
+}
+
+// set runs a set statement and records it
+func (s *session) set(stmt string) error {
+	if _, err := s.conn.ExecContext(context.Background(), stmt); err != nil {
+		return err
+	}
+	s.track(stmt)
+	return nil
+}
+
+// match the name of a session option, and its value
+var (
+	optionNameRe  = regexp.MustCompile(`^[[:alpha:]_][[:alnum:]_ ]*$`)
+	optionValueRe = regexp.MustCompile(`^[^\s;]+$`)
+)
+
+// optionCommand sets a session option, or shows its value if it was set during the session
+func optionCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		return errors.New(`usage: \option name [value]`)
+	}
+
+	if len(args) == 1 {
+		if i, ok := s.optionsIdx[strings.ToLower(args[0])]; ok {
+			fmt.Fprintln(stdout, s.options[i])
+			return nil
+		}
+		return fmt.Errorf("option '%s' was not set during the session", args[0])
+	}
+
+	name, value := strings.Join(args[:len(args)-1], " "), args[len(args)-1]
+	if !optionNameRe.MatchString(name) || !optionValueRe.MatchString(value) {
+		return fmt.Errorf("invalid option '%s %s'", name, value)
+	}
+	return s.set("set " + name + " " + value)
+}
+
+// optionsCommand lists the options set during the session, in order
+func optionsCommand(s *session, args []string) error {
+	if s.database != "" {
+		fmt.Fprintln(stdout, "use", s.database)
+	}
+	for _, stmt := range s.options {
+		fmt.Fprintln(stdout, stmt)
+	}
+	return nil
+}
+
+// isolationLevels describes the transaction isolation levels
+var isolationLevels = []string{"read uncommitted", "read committed", "repeatable read", "serializable"}
+
+// isolationCommand shows or sets the transaction isolation level of the session
+func isolationCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		var level int
+		if err := s.conn.QueryRowContext(context.Background(), "select @@isolation").Scan(&level); err != nil {
+			return err
+		}
+		if level >= 0 && level < len(isolationLevels) {
+			fmt.Fprintf(stdout, "isolation level %d (%s)\n", level, isolationLevels[level])
+			return nil
+		}
+		fmt.Fprintln(stdout, "isolation level", level)
+		return nil
+	}
+
+	level, err := strconv.Atoi(args[0])
+	if err != nil || level < 0 || level >= len(isolationLevels) {
+		return fmt.Errorf("invalid isolation level '%s', expected 0, 1, 2 or 3", args[0])
+	}
+	return s.set(fmt.Sprintf("set transaction isolation level %d", level))
+}
+
+// prepare prepares a query run several times, such as the batches run
+// once per --param-csv record. execBatch uses the statement for this query until unprepare.
+func (s *session) prepare(query string) error {
+	stmt, err := s.conn.PrepareContext(runCtx, query)
+	if err != nil {
+		return err
+	}
+	s.stmt, s.stmtQuery = stmt, query
+	return nil
+}
+
+// unprepare releases the prepared statement, if any
+func (s *session) unprepare() {
+	if s.stmt != nil {
+		s.stmt.Close()
+		s.stmt, s.stmtQuery = nil, ""
+	}
+}
+
+// reconnect opens a new connection with the same parameters,
+// then restores the database and the options.
+func (s *session) reconnect() error {
+	s.unprepare()
+	s.conn.Close()
+
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	// the connection may have failed over to another server
+	if host := s.env()["host"]; host != s.host {
+		s.host = host
+		fmt.Fprintln(os.Stderr, "connected to", s.host)
+	}
+	return s.restore()
+}
+
+// restore runs the login script on a new connection,
+// then restores the database and the options of the session
+func (s *session) restore() (err error) {
+	if err = s.runLoginScript(); err != nil {
+		return err
+	}
+
+	if s.database != "" {
+		if _, err = s.conn.ExecContext(context.Background(), "use "+s.database); err != nil {
+			return fmt.Errorf("could not restore database %s: %s", s.database, err)
+		}
+	}
+
+	for _, stmt := range s.options {
+		if _, err = s.conn.ExecContext(context.Background(), stmt); err != nil {
+			return fmt.Errorf("could not restore option '%s': %s", stmt, err)
+		}
+	}
+	return nil
+}
+
+// fork returns a session on a new connection, in the state of s:
+// same database and options, no transaction. The batches run on it
+// are cancelled along with ctx. The connection is discarded when closed.
+func (s *session) fork(ctx context.Context) (*session, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f := &session{name: s.name, db: s.db, conn: conn, ctx: ctx, database: s.database, host: s.host,
+		loginScript: s.loginScript, options: s.options, optionsIdx: s.optionsIdx,
+		serverType: s.serverType, serverVersion: s.serverVersion}
+	if err = f.restore(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return f, nil
+}
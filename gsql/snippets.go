@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderRe matches the placeholders of a snippet: ${name} or ${name=default}
+var placeholderRe = regexp.MustCompile(`\$\{([[:alpha:]_][[:alnum:]_]*)(?:=([^}]*))?\}`)
+
+// ask prompts for a value, proposing a default one.
+// Set to the readline prompt in interactive sessions,
+// scripts cannot be asked for values.
+var ask = func(question, def string) (string, error) {
+	if def != "" {
+		return def, nil
+	}
+	return "", fmt.Errorf("missing value for %s", question)
+}
+
+// expandSnippet replaces the placeholders of a snippet.
+// Arguments given as name=value are bound by name, the others
+// are bound in the order of appearance of the placeholders.
+// The missing values are asked for.
+func expandSnippet(text string, args []string) (string, error) {
+	values := make(map[string]string)
+	var positional []string
+	for _, arg := range args {
+		if i := strings.Index(arg, "="); i > 0 {
+			values[arg[:i]] = arg[i+1:]
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	for _, m := range placeholderRe.FindAllStringSubmatch(text, -1) {
+		name := m[1]
+		if _, ok := values[name]; ok {
+			continue
+		}
+		if len(positional) > 0 {
+			values[name], positional = positional[0], positional[1:]
+			continue
+		}
+		value, err := ask(name, m[2])
+		if err != nil {
+			return "", err
+		}
+		values[name] = value
+	}
+	if len(positional) > 0 {
+		return "", fmt.Errorf("too many arguments: %s", strings.Join(positional, " "))
+	}
+
+	return placeholderRe.ReplaceAllStringFunc(text, func(p string) string {
+		return values[placeholderRe.FindStringSubmatch(p)[1]]
+	}), nil
+}
+
+// snipCommand runs a snippet defined in the configuration file,
+// or lists them without argument.
+func snipCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		names := make([]string, 0, len(cfg.Snippets))
+		for name := range cfg.Snippets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stdout, "%s\n\t%s\n", name,
+				strings.Replace(strings.TrimSpace(cfg.Snippets[name]), "\n", "\n\t", -1))
+		}
+		return nil
+	}
+
+	text, ok := cfg.Snippets[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown snippet '%s'", args[0])
+	}
+	batch, err := expandSnippet(text, args[1:])
+	if err != nil {
+		return err
+	}
+	if echoInput {
+		fmt.Fprintln(stdout, batch)
+	}
+	return query(s, batch)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// the placeholders are bound by name, then by position, then to their default
+func TestExpandSnippet(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		args     []string
+		expected string
+		hasError bool
+	}{
+		{"select * from ${table}", []string{"t1"}, "select * from t1", false},
+		{"${a} ${b}", []string{"b=2", "1"}, "1 2", false},
+		{"${a} and ${a}", []string{"x"}, "x and x", false},
+		{"select top ${n=10} * from t", nil, "select top 10 * from t", false},
+		{"select top ${n=10} * from t", []string{"5"}, "select top 5 * from t", false},
+		{"${a=1} ${b}", []string{"2"}, "2 ${b}", true},
+		{"no placeholder", nil, "no placeholder", false},
+		{"$table {x}", nil, "$table {x}", false},
+		{"${a}", nil, "", true},
+		{"${a}", []string{"1", "2"}, "", true},
+	} {
+		text, err := expandSnippet(test.text, test.args)
+		if (err != nil) != test.hasError {
+			t.Errorf("%s %v: expected error=%t, got %v", test.text, test.args, test.hasError, err)
+			continue
+		}
+		if err == nil && text != test.expected {
+			t.Errorf("%s %v: expected %q, got %q", test.text, test.args, test.expected, text)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spoolFile receives a copy of all the output when spooling
+var spoolFile *os.File
+
+// stdout is the standard output, duplicated to the spool file
+var stdout io.Writer = teeWriter{os.Stdout}
+
+// teeWriter writes to the underlying writer and to the spool file, if any
+type teeWriter struct {
+	io.Writer
+}
+
+func (t teeWriter) Write(b []byte) (int, error) {
+	if spoolFile != nil {
+		if _, err := spoolFile.Write(b); err != nil {
+			fmt.Fprintln(os.Stderr, "spool failed, stopping:", err)
+			stopSpool()
+		}
+	}
+	return t.Writer.Write(b)
+}
+
+// spool writes to the spool file only, for output already displayed
+// by someone else, such as the commands typed in readline
+func spool(s string) {
+	if spoolFile != nil {
+		fmt.Fprintln(spoolFile, s)
+	}
+}
+
+// stopSpool closes the spool file
+func stopSpool() error {
+	if spoolFile == nil {
+		return nil
+	}
+	err := spoolFile.Close()
+	spoolFile = nil
+	return err
+}
+
+// spoolCommand starts or stops spooling the output to a file
+func spoolCommand(s *session, args []string) (err error) {
+	switch {
+	case len(args) == 0:
+		if spoolFile == nil {
+			fmt.Fprintln(stdout, "not spooling")
+		} else {
+			fmt.Fprintln(stdout, "spooling to", spoolFile.Name())
+		}
+		return nil
+	case len(args) > 1:
+		return errors.New(`usage: \spool file|off`)
+	case args[0] == "off":
+		return stopSpool()
+	}
+
+	if err = stopSpool(); err != nil {
+		return err
+	}
+	spoolFile, err = os.Create(args[0])
+	return err
+}
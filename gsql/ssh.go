@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sshDialer reaches the servers through an SSH bastion.
+// It runs the ssh client, which handles the keys, the agent and the known hosts.
+type sshDialer struct {
+	// user@host[:port]
+	bastion string
+	// identity file, if not the default ones
+	key string
+}
+
+// checkBastion rejects the bastions the ssh client would take for an option
+func checkBastion(bastion string) error {
+	if strings.HasPrefix(bastion, "-") {
+		return fmt.Errorf("invalid ssh bastion %s", bastion)
+	}
+	return nil
+}
+
+// args returns the arguments of the ssh client forwarding its stdio to addr
+func (d sshDialer) args(addr string) []string {
+	host := d.bastion
+	var args []string
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		args = append(args, "-p", host[i+1:])
+		host = host[:i]
+	}
+	if d.key != "" {
+		args = append(args, "-i", d.key)
+	}
+	return append(args, "-o", "ExitOnForwardFailure=yes", "-W", addr, "--",
+		strings.NewReplacer("[", "", "]", "").Replace(host))
+}
+
+// Dial implements tds.Dialer
+func (d sshDialer) Dial(network, addr string) (net.Conn, error) {
+	cmd := exec.Command("ssh", d.args(addr)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start ssh: %s", err)
+	}
+
+	// the pipe provides the deadlines the driver relies on
+	local, remote := net.Pipe()
+	go func() {
+		io.Copy(stdin, remote)
+		stdin.Close()
+	}()
+	go func() {
+		io.Copy(remote, stdout)
+		remote.Close()
+		cmd.Wait()
+	}()
+	return local, nil
+}
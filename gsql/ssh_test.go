@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// the bastion cannot be taken for an option of the ssh client
+func TestSSHArgs(t *testing.T) {
+	for _, test := range []struct {
+		bastion  string
+		expected []string
+		hasError bool
+	}{
+		{"user@host", []string{"-o", "ExitOnForwardFailure=yes", "-W", "db:5000", "--", "user@host"}, false},
+		{"host:2222", []string{"-p", "2222", "-o", "ExitOnForwardFailure=yes", "-W", "db:5000", "--", "host"}, false},
+		{"[::1]:22", []string{"-p", "22", "-o", "ExitOnForwardFailure=yes", "-W", "db:5000", "--", "::1"}, false},
+		{"-oProxyCommand=sh", nil, true},
+	} {
+		if err := checkBastion(test.bastion); (err != nil) != test.hasError {
+			t.Errorf("%s: expected error=%t, got %v", test.bastion, test.hasError, err)
+			continue
+		}
+		if args := (sshDialer{bastion: test.bastion}).args("db:5000"); !test.hasError && !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.bastion, test.expected, args)
+		}
+	}
+}
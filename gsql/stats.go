@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/thda/tds"
+)
+
+// statistics io and time messages
+var (
+	tableIORe = regexp.MustCompile(`Table: (\S+) scan count (\d+), logical reads: \(regular=(\d+) apf=(\d+) total=(\d+)\), physical reads: \(regular=(\d+) apf=(\d+) total=(\d+)\)`)
+	writesRe  = regexp.MustCompile(`Total writes for this command: (\d+)`)
+	cpuRe     = regexp.MustCompile(`Server cpu time: (\d+) ms\.\s+\S+ Server elapsed time: (\d+) ms`)
+	compileRe = regexp.MustCompile(`Parse and Compile Time (\d+)\.`)
+	execRe    = regexp.MustCompile(`Execution Time (\d+)\.`)
+)
+
+// tableIO is the io statistics of a table
+type tableIO struct {
+	scans, logical, physical, apf int
+}
+
+// batchStats accumulates the statistics sent by the server during a batch
+type batchStats struct {
+	tables                     map[string]*tableIO
+	writes, cpu, elapsed       int
+	compileTime, executionTime int
+	received                   bool
+}
+
+var (
+	showStats bool
+	stats     = batchStats{tables: make(map[string]*tableIO)}
+)
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+// add parses a statistics message.
+// Returns false if the message does not contain statistics.
+func (b *batchStats) add(m tds.SybError) bool {
+	if m.Severity != 10 {
+		return false
+	}
+	if sm := tableIORe.FindStringSubmatch(m.Message); sm != nil {
+		t, ok := b.tables[sm[1]]
+		if !ok {
+			t = &tableIO{}
+			b.tables[sm[1]] = t
+		}
+		t.scans += atoi(sm[2])
+		t.logical += atoi(sm[5])
+		t.physical += atoi(sm[8])
+		t.apf += atoi(sm[4]) + atoi(sm[7])
+	} else if sm := writesRe.FindStringSubmatch(m.Message); sm != nil {
+		b.writes += atoi(sm[1])
+	} else if sm := cpuRe.FindStringSubmatch(m.Message); sm != nil {
+		b.cpu += atoi(sm[1])
+		b.elapsed += atoi(sm[2])
+	} else if sm := compileRe.FindStringSubmatch(m.Message); sm != nil {
+		b.compileTime += atoi(sm[1])
+	} else if sm := execRe.FindStringSubmatch(m.Message); sm != nil {
+		b.executionTime += atoi(sm[1])
+	} else {
+		return false
+	}
+	b.received = true
+	return true
+}
+
+// print displays the summary of the batch statistics and resets them
+func (b *batchStats) print() {
+	if !b.received {
+		return
+	}
+
+	names := make([]string, 0, len(b.tables))
+	for name := range b.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(stdout, colorize(colorCyan, "---- statistics ----"))
+	if len(names) > 0 {
+		tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "table\tscans\tlogical reads\tphysical reads\tapf reads\t")
+		for _, name := range names {
+			t := b.tables[name]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n", name, t.scans, t.logical, t.physical, t.apf)
+		}
+		tw.Flush()
+	}
+	fmt.Fprintf(stdout, "writes: %d, cpu: %d ms, elapsed: %d ms, parse and compile: %d ms, execution: %d ms\n",
+		b.writes, b.cpu, b.elapsed, b.compileTime, b.executionTime)
+
+	*b = batchStats{tables: make(map[string]*tableIO)}
+}
+
+// statsCommand toggles the statistics io and time session options
+func statsCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "statistics are", onOff(showStats))
+		return nil
+	}
+	on, err := parseOnOff(args[0])
+	if err != nil {
+		return err
+	}
+	for _, option := range []string{"io", "time"} {
+		if err = s.set("set statistics " + option + " " + onOff(on)); err != nil {
+			return err
+		}
+	}
+	showStats = on
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	// template of the rows, and its parsed form
+	templateText string
+	rowTemplate  *template.Template
+)
+
+// unescape interprets the escape sequences of a template given on the command line
+var unescape = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\r`, "\r", `\\`, `\`)
+
+// templateFuncs are the functions available in the row template
+var templateFuncs = template.FuncMap{
+	// date formats a date with a go time layout
+	"date": func(layout string, v interface{}) string {
+		if t, ok := v.(time.Time); ok {
+			return t.Format(layout)
+		}
+		return formatValue(v)
+	},
+	// number formats a number with a pattern such as #,##0.00
+	"number": func(pattern string, v interface{}) (string, error) {
+		n := &numFormat{}
+		if err := n.Set(pattern); err != nil {
+			return "", err
+		}
+		if s, ok := n.format(v); ok {
+			return s, nil
+		}
+		return formatValue(v), nil
+	},
+	// format formats a value with the display settings
+	"format": formatValue,
+	// default returns def if the value is null or empty
+	"default": func(def string, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// parseTemplate parses the template given with --template
+func parseTemplate(text string) (err error) {
+	rowTemplate, err = template.New("row").Funcs(templateFuncs).Option("missingkey=error").
+		Parse(unescape.Replace(text))
+	return err
+}
+
+// encodeTemplate executes the template for each row of the current result set.
+// The values are accessed by column name, as in {{.name}}. Nulls are empty.
+func encodeTemplate(w io.Writer, rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	return eachRow(rs, func(row []interface{}) error {
+		values := make(map[string]interface{}, len(cols))
+		for i, name := range cols {
+			values[name] = row[i]
+			if row[i] == nil {
+				values[name] = ""
+			}
+		}
+		return rowTemplate.Execute(w, values)
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/thda/tds"
+)
+
+// cell styles, as declared in styles.xml
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleDate
+	xlsxStyleHeader
+)
+
+// workbook writes each result set to a worksheet of an xlsx file.
+// The file is only valid once the workbook is closed.
+type workbook struct {
+	z      *zip.Writer
+	sheets int
+}
+
+func newWorkbook(w io.Writer) *workbook {
+	return &workbook{z: zip.NewWriter(w)}
+}
+
+// xlsxColumn returns the name of the column of the given index (0 => A)
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSerial converts a date to the number of days since 1899-12-30
+func xlsxSerial(t time.Time) float64 {
+	_, offset := t.Zone()
+	secs := t.Unix() + int64(offset)
+	return float64(secs)/86400 + 25569 + float64(t.Nanosecond())/86400e9
+}
+
+// writeCell writes a cell, typed according to the value
+func writeCell(b *bytes.Buffer, ref string, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case int64:
+		fmt.Fprintf(b, `<c r="%s"><v>%d</v></c>`, ref, v)
+	case uint64:
+		fmt.Fprintf(b, `<c r="%s"><v>%d</v></c>`, ref, v)
+	case float64:
+		fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'g', -1, 64))
+	case tds.Num:
+		fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, v.String())
+	case bool:
+		fmt.Fprintf(b, `<c r="%s" t="b"><v>%d</v></c>`, ref, map[bool]int{false: 0, true: 1}[v])
+	case time.Time:
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDate,
+			strconv.FormatFloat(xlsxSerial(v), 'f', -1, 64))
+	case []byte:
+		writeStringCell(b, ref, "0x"+hex.EncodeToString(v), xlsxStyleDefault)
+	case string:
+		writeStringCell(b, ref, v, xlsxStyleDefault)
+	default:
+		writeStringCell(b, ref, fmt.Sprint(v), xlsxStyleDefault)
+	}
+}
+
+func writeStringCell(b *bytes.Buffer, ref string, s string, style int) {
+	fmt.Fprintf(b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+	xml.EscapeText(b, []byte(s))
+	b.WriteString(`</t></is></c>`)
+}
+
+// addSheet writes the current result set to a new worksheet,
+// with a frozen header row.
+func (wb *workbook) addSheet(rs *resultSet) error {
+	cols, err := rs.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	wb.sheets++
+	w, err := wb.z.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", wb.sheets))
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0">` +
+		`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>` +
+		`</sheetView></sheetViews><sheetData><row r="1">`)
+	for i, col := range cols {
+		writeStringCell(&b, xlsxColumn(i)+"1", col, xlsxStyleHeader)
+	}
+	b.WriteString(`</row>`)
+
+	vals := make([]interface{}, len(cols))
+	for i := range vals {
+		vals[i] = new(interface{})
+	}
+
+	for line := 2; rs.Next(); line++ {
+		if err = rs.Scan(vals...); err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, `<row r="%d">`, line)
+		for i, v := range vals {
+			writeCell(&b, xlsxColumn(i)+strconv.Itoa(line), *(v.(*interface{})))
+		}
+		b.WriteString(`</row>`)
+
+		// write by chunks to keep memory low
+		if b.Len() > 1<<16 {
+			if _, err = b.WriteTo(w); err != nil {
+				return err
+			}
+		}
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	_, err = b.WriteTo(w)
+	return err
+}
+
+// Close writes the workbook's metadata and terminates the zip archive.
+func (wb *workbook) Close() error {
+	// a workbook needs at least one sheet
+	if wb.sheets == 0 {
+		wb.sheets++
+		w, err := wb.z.Create("xl/worksheets/sheet1.xml")
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`)
+	}
+
+	var contentTypes, sheets, rels bytes.Buffer
+	for i := 1; i <= wb.sheets; i++ {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+		fmt.Fprintf(&sheets, `<sheet name="Result %d" sheetId="%d" r:id="rId%d"/>`, i, i, i)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			contentTypes.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels.String() + fmt.Sprintf(`<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" `+
+			`Target="styles.xml"/>`, wb.sheets+1) + `</Relationships>`},
+		// default, date time (built-in format 22) and bold header styles
+		{"xl/styles.xml", `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font>` +
+			`<font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+			`<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+			`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+
+	for _, f := range files {
+		w, err := wb.z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, xml.Header+f.content); err != nil {
+			return err
+		}
+	}
+	return wb.z.Close()
+}
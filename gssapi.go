@@ -0,0 +1,183 @@
+//go:build gssapi && cgo && !darwin && !windows
+// +build gssapi,cgo,!darwin,!windows
+
+package tds
+
+/*
+#cgo LDFLAGS: -lgssapi_krb5
+
+#include <stdint.h>
+#include <stdlib.h>
+
+// declarations of RFC 2744, the GSSAPI C bindings,
+// so that the library's headers are not required to build
+typedef uint32_t OM_uint32;
+typedef struct gss_OID_desc_struct { OM_uint32 length; void *elements; } gss_OID_desc, *gss_OID;
+typedef struct gss_buffer_desc_struct { size_t length; void *value; } gss_buffer_desc, *gss_buffer_t;
+typedef struct gss_name_struct *gss_name_t;
+typedef struct gss_ctx_id_struct *gss_ctx_id_t;
+typedef struct gss_cred_id_struct *gss_cred_id_t;
+typedef struct gss_channel_bindings_struct *gss_channel_bindings_t;
+
+OM_uint32 gss_import_name(OM_uint32 *, gss_buffer_t, gss_OID, gss_name_t *);
+OM_uint32 gss_release_name(OM_uint32 *, gss_name_t *);
+OM_uint32 gss_init_sec_context(OM_uint32 *, gss_cred_id_t, gss_ctx_id_t *, gss_name_t, gss_OID,
+	OM_uint32, OM_uint32, gss_channel_bindings_t, gss_buffer_t, gss_OID *, gss_buffer_t,
+	OM_uint32 *, OM_uint32 *);
+OM_uint32 gss_delete_sec_context(OM_uint32 *, gss_ctx_id_t *, gss_buffer_t);
+OM_uint32 gss_release_buffer(OM_uint32 *, gss_buffer_t);
+OM_uint32 gss_display_status(OM_uint32 *, OM_uint32, int, gss_OID, OM_uint32 *, gss_buffer_t);
+
+// the Kerberos v5 mechanism and principal name type
+static gss_OID_desc krb5Mech = {9, "\x2a\x86\x48\x86\xf7\x12\x01\x02\x02"};
+static gss_OID_desc krb5Principal = {10, "\x2a\x86\x48\x86\xf7\x12\x01\x02\x02\x01"};
+
+// mutual authentication, replay and sequence detection
+#define SEC_FLAGS (2 | 4 | 8)
+
+static OM_uint32 importName(OM_uint32 *minor, char *name, size_t len, gss_name_t *out) {
+	gss_buffer_desc buf = {len, name};
+	return gss_import_name(minor, &buf, &krb5Principal, out);
+}
+
+// initSecContext runs a step of the negotiation with the default credentials
+static OM_uint32 initSecContext(OM_uint32 *minor, gss_ctx_id_t *ctx, gss_name_t target,
+		void *in, size_t len, gss_buffer_t out) {
+	gss_buffer_desc input = {len, in};
+	return gss_init_sec_context(minor, NULL, ctx, target, &krb5Mech, SEC_FLAGS, 0, NULL,
+		len ? &input : NULL, NULL, out, NULL, NULL);
+}
+
+static OM_uint32 displayStatus(OM_uint32 *minor, OM_uint32 status, int kind,
+		OM_uint32 *msgCtx, gss_buffer_t out) {
+	return gss_display_status(minor, status, kind, &krb5Mech, msgCtx, out);
+}
+*/
+import "C"
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// GSSAPI status
+const (
+	gssErrorMask      = 0xffff0000
+	gssContinueNeeded = 1
+	gssCode           = 1
+	gssMechCode       = 2
+)
+
+// the system's GSSAPI library provides the Kerberos authentication
+func init() {
+	RegisterAuthenticator("kerberos", newGSSAPIAuthenticator)
+}
+
+// gssapiAuthenticator negotiates a Kerberos security session with the system's GSSAPI library.
+// The credentials are the ones of the default cache, as obtained by kinit.
+type gssapiAuthenticator struct {
+	target   C.gss_name_t
+	ctx      C.gss_ctx_id_t
+	complete bool
+}
+
+// newGSSAPIAuthenticator returns an authenticator for the service principal
+// given by the spn parameter, such as ASE/host@REALM
+func newGSSAPIAuthenticator(params url.Values) (Authenticator, error) {
+	spn := params.Get("spn")
+	if spn == "" {
+		return nil, errors.New("the spn parameter is required")
+	}
+	name := C.CString(spn)
+	defer C.free(unsafe.Pointer(name))
+
+	a := &gssapiAuthenticator{}
+	var minor C.OM_uint32
+	if major := C.importName(&minor, name, C.size_t(len(spn)), &a.target); major&gssErrorMask != 0 {
+		return nil, gssError(major, minor)
+	}
+	runtime.SetFinalizer(a, (*gssapiAuthenticator).release)
+	return a, nil
+}
+
+func (a *gssapiAuthenticator) Mechanism() asn1.ObjectIdentifier {
+	return KerberosMechanism
+}
+
+func (a *gssapiAuthenticator) InitialToken() ([]byte, error) {
+	return a.step(nil)
+}
+
+func (a *gssapiAuthenticator) NextToken(serverToken []byte) ([]byte, error) {
+	if a.complete {
+		return nil, nil
+	}
+	return a.step(serverToken)
+}
+
+// step runs gss_init_sec_context with the server's token, if any,
+// and returns the token to send
+func (a *gssapiAuthenticator) step(in []byte) ([]byte, error) {
+	var p unsafe.Pointer
+	if len(in) > 0 {
+		p = C.CBytes(in)
+		defer C.free(p)
+	}
+
+	var minor C.OM_uint32
+	var out C.gss_buffer_desc
+	major := C.initSecContext(&minor, &a.ctx, a.target, p, C.size_t(len(in)), &out)
+	defer func() {
+		var minor C.OM_uint32
+		C.gss_release_buffer(&minor, &out)
+	}()
+	if major&gssErrorMask != 0 {
+		return nil, gssError(major, minor)
+	}
+	a.complete = major&gssContinueNeeded == 0
+
+	if out.length == 0 {
+		return nil, nil
+	}
+	return C.GoBytes(out.value, C.int(out.length)), nil
+}
+
+// release frees the name and the security context
+func (a *gssapiAuthenticator) release() {
+	var minor C.OM_uint32
+	if a.ctx != nil {
+		C.gss_delete_sec_context(&minor, &a.ctx, nil)
+	}
+	if a.target != nil {
+		C.gss_release_name(&minor, &a.target)
+	}
+}
+
+// gssError returns the messages of a GSSAPI status, and of the mechanism's status
+func gssError(major, minor C.OM_uint32) error {
+	msgs := gssStatus(major, gssCode)
+	if minor != 0 {
+		msgs = append(msgs, gssStatus(minor, gssMechCode)...)
+	}
+	return fmt.Errorf("gssapi: %s", strings.Join(msgs, ": "))
+}
+
+func gssStatus(status C.OM_uint32, kind C.int) (msgs []string) {
+	var minor, msgCtx C.OM_uint32
+	for {
+		var buf C.gss_buffer_desc
+		if C.displayStatus(&minor, status, kind, &msgCtx, &buf)&gssErrorMask != 0 {
+			return msgs
+		}
+		msgs = append(msgs, C.GoStringN((*C.char)(buf.value), C.int(buf.length)))
+		C.gss_release_buffer(&minor, &buf)
+		if msgCtx == 0 {
+			return msgs
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package tds
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LookupInterfaces returns the query addresses of a server, as host:port,
+// read from an interfaces file (unix) or a sql.ini file (windows).
+//
+// The addresses are returned in the order of the file,
+// the ones following the first being the fallback entries.
+func LookupInterfaces(path, name string) (addrs []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var current string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';' {
+			continue
+		}
+
+		// server name, at the start of the line, or between brackets in sql.ini
+		if trimmed[0] == '[' || (line[0] != ' ' && line[0] != '\t' && !strings.Contains(line, "=")) {
+			if current == name && len(addrs) > 0 {
+				break
+			}
+			current = strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			if fields := strings.Fields(current); len(fields) > 0 {
+				current = fields[0]
+			}
+			continue
+		}
+
+		if current != name {
+			continue
+		}
+		addr, ok := parseInterfacesEntry(trimmed)
+		if ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("tds: server %s not found in %s", name, path)
+	}
+	return addrs, nil
+}
+
+// parseInterfacesEntry returns the address of a query entry.
+// The supported forms are:
+//
+//	query tcp ether host port
+//	query tli tcp /dev/tcp \x00021f90c0a8010a0000000000000000
+//	query=TCP,host,port
+func parseInterfacesEntry(entry string) (addr string, ok bool) {
+	// sql.ini
+	if i := strings.Index(entry, "="); i >= 0 {
+		if !strings.EqualFold(strings.TrimSpace(entry[:i]), "query") {
+			return "", false
+		}
+		fields := strings.Split(entry[i+1:], ",")
+		if len(fields) < 3 {
+			return "", false
+		}
+		return net.JoinHostPort(strings.TrimSpace(fields[1]), strings.TrimSpace(fields[2])), true
+	}
+
+	fields := strings.Fields(entry)
+	if len(fields) < 4 || fields[0] != "query" {
+		return "", false
+	}
+
+	// tli entries encode the family, the port and the address in hexadecimal
+	if fields[1] == "tli" {
+		b, err := hex.DecodeString(strings.TrimPrefix(fields[len(fields)-1], `\x`))
+		if err != nil || len(b) < 8 {
+			return "", false
+		}
+		port := int(b[2])<<8 | int(b[3])
+		return net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(port)), true
+	}
+
+	if len(fields) < 5 {
+		return "", false
+	}
+	return net.JoinHostPort(fields[3], fields[4]), true
+}
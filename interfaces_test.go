@@ -0,0 +1,47 @@
+package tds
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLookupInterfaces(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		name    string
+		addrs   []string
+	}{
+		{"# comment\nPROD\n\tmaster tcp ether db1 5000\n\tquery tcp ether db1 5000\n" +
+			"\tquery tcp ether db2 5000\n\nDEV\n\tquery tcp ether dev 5100\n",
+			"PROD", []string{"db1:5000", "db2:5000"}},
+		{"PROD\n\tquery tcp ether db1 5000\nDEV\n\tquery tcp ether dev 5100\n",
+			"DEV", []string{"dev:5100"}},
+		{"PROD\n\tquery tli tcp /dev/tcp \\x00021388c0a8010a0000000000000000\n",
+			"PROD", []string{"192.168.1.10:5000"}},
+		{"[PROD]\r\nmaster=TCP,db1,5000\r\nquery=TCP,db1,5000\r\n[DEV]\r\nquery=TCP,dev,5100\r\n",
+			"PROD", []string{"db1:5000"}},
+	} {
+		f, err := ioutil.TempFile("", "interfaces")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(test.content)
+		f.Close()
+
+		addrs, err := LookupInterfaces(f.Name(), test.name)
+		if err != nil {
+			t.Error("LookupInterfaces failed:", err)
+			continue
+		}
+		if !reflect.DeepEqual(addrs, test.addrs) {
+			t.Errorf("expected %v for %s, got %v", test.addrs, test.name, addrs)
+		}
+
+		if _, err = LookupInterfaces(f.Name(), "UNKNOWN"); err == nil {
+			t.Error("LookupInterfaces should fail for an unknown server")
+		}
+	}
+}
@@ -715,6 +715,7 @@ var (
 	loginSecEncrypt1 = uint8(1)
 	loginSecEncrypt2 = uint8(32)
 	loginSecNonce    = uint8(128)
+	loginSecSession  = uint8(0x10)
 )
 
 // login is the tds v5 login packet
@@ -851,8 +852,9 @@ func (l *login) Read(e *bin.Encoder) error {
 
 // login status
 const (
-	loginSuccessToken = 0x05
-	loginFailedToken  = 0x06
+	loginSuccessToken   = 0x05
+	loginFailedToken    = 0x06
+	loginNegotiateToken = 0x07
 )
 
 // LoginAck is the login ack packet
@@ -867,7 +869,7 @@ type loginAck struct {
 
 // Write serializes a TdsLoginAck struct
 func (l loginAck) Write(e *bin.Encoder) error {
-	e.WriteByte(loginSuccessToken)
+	e.WriteInt8(l.ack)
 	e.Write(l.tdsVersion[:])
 	e.WriteStringWithLen(8, l.server)
 	e.Write(l.serverVersion[:])
@@ -15,6 +15,7 @@ import (
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"errors"
@@ -60,6 +61,9 @@ type session struct {
 	server     string
 	serverType string
 
+	// transaction state reported by the last done token
+	tranState int16
+
 	// tokens for reuse
 	envChange    envChange
 	done         done
@@ -87,6 +91,10 @@ func newLogin(prm connParams) *login {
 		l.encrypted = 0
 		l.password, l.password2 = prm.password, prm.password
 	}
+	// the security session replaces the password
+	if prm.auth != "" {
+		l.encrypted = loginSecSession
+	}
 	return l
 }
 
@@ -102,14 +110,13 @@ func newSession(prm connParams) (s *session, err error) {
 
 	// init resultset, buffer, parameters, message cache...
 	s.res.s = s
-	s.server = prm.host
 	s.messageMap = map[token]messageReader{envChangeToken: &s.envChange,
 		doneProcToken: &s.done, doneInProcToken: &s.done,
 		doneToken: &s.done, returnStatusToken: &s.returnStatus,
 		sqlMessageToken: &s.sqlMessage}
 
 	// connect
-	if s.c, err = dial(prm); err != nil {
+	if s.c, s.server, err = dial(prm); err != nil {
 		return s, err
 	}
 
@@ -156,19 +163,54 @@ func newSession(prm connParams) (s *session, err error) {
 	return s, nil
 }
 
-// dial connects to the target host and returns a writer.
-func dial(prm connParams) (io.ReadWriteCloser, error) {
-	if prm.ssl == "on" {
-		return tls.DialWithDialer(&net.Dialer{Timeout: time.Duration(prm.loginTimeout) * time.Second},
-			"tcp", prm.host, &tls.Config{InsecureSkipVerify: true})
+// dial connects to the first reachable host and returns a writer,
+// along with the address of the host.
+func dial(prm connParams) (c io.ReadWriteCloser, host string, err error) {
+	for _, host = range strings.Split(prm.host, ",") {
+		if prm.dialer != nil {
+			c, err = dialCustom(prm, host)
+		} else if prm.ssl == "on" {
+			c, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Duration(prm.loginTimeout) * time.Second},
+				"tcp", host, prm.tlsConfig)
+		} else {
+			c, err = net.DialTimeout("tcp", host,
+				time.Duration(prm.loginTimeout)*time.Second)
+		}
+		if err == nil {
+			return c, host, nil
+		}
 	}
+	return nil, "", err
+}
 
-	return net.DialTimeout("tcp", prm.host,
-		time.Duration(prm.loginTimeout)*time.Second)
+// dialCustom connects to a host with the dialer set on the driver
+func dialCustom(prm connParams, host string) (io.ReadWriteCloser, error) {
+	c, err := prm.dialer.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if prm.ssl != "on" {
+		return c, nil
+	}
+
+	cfg := prm.tlsConfig.Clone()
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		cfg.ServerName, _, _ = net.SplitHostPort(host)
+	}
+	tc := tls.Client(c, cfg)
+	if prm.loginTimeout > 0 {
+		tc.SetDeadline(time.Now().Add(time.Duration(prm.loginTimeout) * time.Second))
+		defer tc.SetDeadline(time.Time{})
+	}
+	if err = tc.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
 }
 
 // login sends the login packets. Login and capabilities required.
-// If asked, it will also handle password encryption.
+// If asked, it will also handle password encryption, or the security session negotiation.
 func (s *session) login(prm connParams) (err error) {
 	login := newLogin(prm)
 	login.msg = msg{flags: fixedSize}
@@ -187,14 +229,41 @@ func (s *session) login(prm connParams) (err error) {
 	s.clearResult()
 
 	loginAck := &loginAck{msg: newMsg(loginAckToken)}
+	if prm.auth != "" {
+		err = s.negotiate(ctx, prm, loginAck)
+	} else {
+		err = s.passwordLogin(ctx, prm, loginAck)
+	}
+	if err != nil {
+		return err
+	}
+
+	if loginAck.ack != loginSuccessToken {
+		return errors.New("tds: login failed. Please check username/password")
+	}
+	// we are logged in
+	s.valid = true
+
+	// keep the server name provided in the loginAck
+	s.serverType = loginAck.server
+
+	// use the proper database
+	if prm.database != "" {
+		if _, err = s.simpleExec(ctx, "use "+prm.database); err != nil {
+			return fmt.Errorf("tds: use database failed: %s", err)
+		}
+	}
+
+	return err
+}
+
+// readLoginResponse reads the login acknowledgement,
+// and returns the parameters sent along, such as an auth challenge
+func (s *session) readLoginResponse(ctx context.Context, loginAck *loginAck) ([]driver.Value, error) {
 	pf := &columns{msg: newMsg(paramFmtToken)}
 	p := &row{msg: newMsg(paramToken)}
+	loginAck.ack = 0
 
-	// only retry once
-	try := 0
-
-	// get login ack/auth challenge message
-loginResponse:
 	for f := s.initState(ctx,
 		map[token]messageReader{loginAckToken: loginAck,
 			capabilitiesToken: &s.capabilities,
@@ -207,14 +276,29 @@ loginResponse:
 	}
 
 	if s.state.err != nil && s.state.err != io.EOF {
-		return s.state.err
+		return nil, s.state.err
 	}
+	return p.data, nil
+}
+
+// passwordLogin reads the login acknowledgement,
+// sending the password encrypted if the server asks for it.
+func (s *session) passwordLogin(ctx context.Context, prm connParams, loginAck *loginAck) error {
+	// only retry once
+	for try := 0; ; try++ {
+		// get login ack/auth challenge message
+		params, err := s.readLoginResponse(ctx, loginAck)
+		if err != nil {
+			return err
+		}
+
+		// RSA encryption supported, extract the public key
+		if len(params) == 0 || try > 0 {
+			return nil
+		}
 
-	// RSA encryption supported, extract the public key
-	// only 1 try
-	if len(p.data) > 0 && try == 0 {
 		// check if server supports RSA encryption
-		if rsa, ok := p.data[0].(int64); ok {
+		if rsa, ok := params[0].(int64); ok {
 			if rsa != 1 {
 				return ErrUnsupportedPassWordEncrytion
 			}
@@ -223,22 +307,21 @@ loginResponse:
 		}
 
 		// get rsa public key, and encrypt
-		try = 1
-		block, _ := pem.Decode(p.data[1].([]byte))
+		block, _ := pem.Decode(params[1].([]byte))
 		if block == nil {
 			return ErrUnsupportedPassWordEncrytion
 		}
 
 		var pk rsa.PublicKey
-		_, err := asn1.Unmarshal(block.Bytes, &pk)
+		_, err = asn1.Unmarshal(block.Bytes, &pk)
 		if err != nil {
 			return ErrUnsupportedPassWordEncrytion
 		}
 
 		// nonce introduces randomness to avoid replay attacks
 		var message []byte
-		if len(p.data) > 2 {
-			nonce := p.data[2].([]byte)
+		if len(params) > 2 {
+			nonce := params[2].([]byte)
 			message = append(nonce, []byte(prm.password)...)
 		} else {
 			// no nonce, do not know this encryption method
@@ -271,28 +354,7 @@ loginResponse:
 		if err = s.b.send(ctx, normalPacket, msg1, cols1, row1, msg2, cols2, row2); err != nil {
 			return fmt.Errorf("tds: login send failed: %s", err)
 		}
-
-		// re-read, we should get login ack now
-		goto loginResponse
 	}
-
-	if loginAck.ack != 5 {
-		return errors.New("tds: login failed. Please check username/password")
-	}
-	// we are logged in
-	s.valid = true
-
-	// keep the server name provided in the loginAck
-	s.serverType = loginAck.server
-
-	// use the proper database
-	if prm.database != "" {
-		if _, err = s.simpleExec(ctx, "use "+prm.database); err != nil {
-			return fmt.Errorf("tds: use database failed: %s", err)
-		}
-	}
-
-	return err
 }
 
 // checkErr check if the given error is fatal.
@@ -622,6 +684,7 @@ func (s *session) processDone(t token) (err error) {
 
 	// last bit set
 	s.res.final = s.done.status&doneMoreResults == 0
+	s.tranState = s.done.tranState
 
 	// return error if found during this message stream.
 	if s.res.final {
@@ -7,6 +7,7 @@ import (
 	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/thda/tds/binary"
@@ -827,6 +828,15 @@ var ErrBadType = errors.New("invalid type given")
 // ErrNonNullable is raised when trying to insert null values to non-null fields
 var ErrNonNullable = errors.New("trying to insert null values into non null column")
 
+// parseError returns ErrOverFlow for the numbers out of range
+// given as strings, ErrBadType otherwise
+func parseError(err error) error {
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+		return ErrOverFlow
+	}
+	return ErrBadType
+}
+
 // typeCheckConverter just run type checks
 type typeCheckConverter struct {
 	expectedType reflect.Type
@@ -851,14 +861,28 @@ func (b boolConverter) ConvertValue(src interface{}) (driver.Value, error) {
 		return nil, ErrNonNullable
 	}
 
+	// parse strings
+	if str, ok := src.(string); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(str))
+		if err != nil {
+			return nil, ErrBadType
+		}
+		return b, nil
+	}
+
 	if _, ok := src.(bool); !ok {
 		return nil, ErrBadType
 	}
 	return src, nil
 }
 
+// layouts of the dates given as strings
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999",
+	"2006-01-02", "15:04:05.999999999"}
+
 // dateConverter just checks for overflows
-// Right now you can only give time.Time and *time.Time parameters
+// Right now you can only give time.Time, *time.Time and strings
+// in one of the dateLayouts formats
 type dateConverter struct {
 	min     time.Time
 	max     time.Time
@@ -879,6 +903,16 @@ func (d dateConverter) ConvertValue(src interface{}) (driver.Value, error) {
 		val = *src.(*time.Time)
 	case time.Time:
 		val = src.(time.Time)
+	case string:
+		var err error
+		for _, layout := range dateLayouts {
+			if val, err = time.Parse(layout, strings.TrimSpace(src.(string))); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, ErrBadType
+		}
 	}
 
 	// no date, no range check
@@ -926,6 +960,17 @@ func (i intConverter) ConvertValue(src interface{}) (driver.Value, error) {
 			return nil, nil
 		}
 		return i.ConvertValue(rv.Elem().Interface())
+	case reflect.String:
+		str := strings.TrimSpace(rv.String())
+		var err error
+		if i64, err = strconv.ParseInt(str, 10, 64); err == nil {
+			return i.ConvertValue(i64)
+		} else if strings.HasPrefix(str, "-") {
+			return nil, parseError(err)
+		}
+		if u64, err = strconv.ParseUint(str, 10, 64); err != nil {
+			return nil, parseError(err)
+		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
 		i64 = rv.Int()
 		// overflow check
@@ -981,6 +1026,11 @@ func (f floatConverter) ConvertValue(src interface{}) (driver.Value, error) {
 			return nil, nil
 		}
 		return f.ConvertValue(rv.Elem().Interface())
+	case reflect.String:
+		var err error
+		if f64, err = strconv.ParseFloat(strings.TrimSpace(rv.String()), 64); err != nil {
+			return nil, parseError(err)
+		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		f64 = float64(rv.Int())
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
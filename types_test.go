@@ -0,0 +1,54 @@
+package tds
+
+import (
+	"database/sql/driver"
+	"math"
+	"testing"
+	"time"
+)
+
+// parameters given as strings are parsed to the parameter's type
+func TestConvertStrings(t *testing.T) {
+	for _, test := range []struct {
+		c        driver.ValueConverter
+		src      string
+		expected driver.Value
+	}{
+		{intConverter{min: -128, max: 127}, "-12", int64(-12)},
+		{floatConverter{max: 1e10}, "1.5", 1.5},
+		{boolConverter{}, "true", true},
+		{dateConverter{}, "2019-01-02", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{intConverter{min: -128, max: 127}, " 12 ", int64(12)},
+		{floatConverter{max: 1e10}, "\t1.5\n", 1.5},
+		{boolConverter{}, " false", false},
+		{dateConverter{}, "2019-01-02 ", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+	} {
+		v, err := test.c.ConvertValue(test.src)
+		if err != nil {
+			t.Errorf("conversion of %s failed: %s", test.src, err)
+			continue
+		}
+		if v != test.expected {
+			t.Errorf("expected %v for %s, got %v", test.expected, test.src, v)
+		}
+	}
+
+	if _, err := (intConverter{min: -128, max: 127}).ConvertValue("128"); err != ErrOverFlow {
+		t.Error("expected an overflow, got", err)
+	}
+	for _, test := range []struct {
+		c   driver.ValueConverter
+		src string
+	}{
+		{intConverter{min: math.MinInt64, max: math.MaxUint64}, "18446744073709551616"},
+		{intConverter{min: math.MinInt64, max: math.MaxUint64}, "-9223372036854775809"},
+		{floatConverter{max: math.MaxFloat64}, "1e400"},
+	} {
+		if _, err := test.c.ConvertValue(test.src); err != ErrOverFlow {
+			t.Errorf("expected an overflow for %s, got %v", test.src, err)
+		}
+	}
+	if _, err := (intConverter{min: -128, max: 127}).ConvertValue("abc"); err != ErrBadType {
+		t.Error("expected a bad type error, got", err)
+	}
+}